@@ -1,15 +1,58 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 
 	"github.com/your-username/go-l7-proxy/pkg/proxy"
+	"github.com/your-username/go-l7-proxy/pkg/proxy/auth"
+	"github.com/your-username/go-l7-proxy/pkg/proxy/upstream"
+)
+
+// loadFilterConfig reads filterConfigFile and atomically swaps it into
+// filter's ruleset.
+func loadFilterConfig(filter *proxy.RequestFilter, filterConfigFile string) error {
+	data, err := os.ReadFile(filterConfigFile)
+	if err != nil {
+		return err
+	}
+	cfg, err := proxy.ParseFilterConfig(data)
+	if err != nil {
+		return err
+	}
+	return filter.ReloadRules(cfg)
+}
+
+// drainTimeout bounds how long subsystems wait for in-flight connections to
+// finish on their own during a graceful shutdown before force-closing them.
+const drainTimeout = 10 * time.Second
+
+// defaultUpstreamProbeURL is fetched through each pooled upstream to judge
+// its health.
+const defaultUpstreamProbeURL = "http://www.gstatic.com/generate_204"
+
+// defaultAuthRealm is presented to clients in the Proxy-Authenticate
+// challenge when PROXY_AUTH_REALM isn't set.
+const defaultAuthRealm = "l7proxy"
+
+// defaultMITMCACert and defaultMITMCAKey are used when PROXY_MITM_CA_CERT /
+// PROXY_MITM_CA_KEY aren't set but MITM has still been requested.
+const (
+	defaultMITMCACert = "/etc/l7proxy/mitm-ca.crt"
+	defaultMITMCAKey  = "/etc/l7proxy/mitm-ca.key"
 )
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Create connection manager and filter instances
 	connManager := proxy.NewConnManager()
 	filter := proxy.NewRequestFilter()
@@ -17,55 +60,177 @@ func main() {
 	// HAProxy TLS termination loopback address
 	haproxyAddr := "127.0.0.1:8443"
 
-	startHTTPProxy(connManager, filter)
-	startHTTPSProxy(connManager, filter, haproxyAddr, true) // TLS termination enabled
-	startHAProxyForwardedListener(connManager, filter)
-	startMetricsEndpoint()
-	startFileWatcher(connManager)
+	mitmEngine, err := newMITMEngine()
+	if err != nil {
+		log.Fatalf("Failed to initialize MITM engine: %v", err)
+	}
+
+	pool, err := newUpstreamPool()
+	if err != nil {
+		log.Fatalf("Failed to initialize upstream pool: %v", err)
+	}
+
+	authenticator, err := newAuthenticator()
+	if err != nil {
+		log.Fatalf("Failed to initialize proxy authenticator: %v", err)
+	}
+
+	deps := &proxy.Deps{
+		ConnManager:  connManager,
+		Filter:       filter,
+		MITMEngine:   mitmEngine,
+		UpstreamPool: pool,
+		Auth:         authenticator,
+	}
 
-	// Setup graceful shutdown on SIGINT/SIGTERM
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	g, gctx := errgroup.WithContext(ctx)
 
-	<-stop
+	if pool != nil {
+		g.Go(func() error {
+			pool.Run(gctx)
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		return proxy.StartHTTPServer(gctx, ":80", deps, drainTimeout)
+	})
+	g.Go(func() error {
+		return proxy.StartHTTPSServer(gctx, ":443", deps, haproxyAddr, true, drainTimeout) // TLS termination enabled
+	})
+	g.Go(func() error {
+		return proxy.StartHAProxyListener(gctx, ":8080", deps, drainTimeout)
+	})
+	g.Go(func() error {
+		return proxy.StartMetricsServer(gctx, ":9090", drainTimeout)
+	})
+	g.Go(func() error {
+		return proxy.StartFileWatcher(gctx, "/tmp/proxy-trigger.txt", func() {
+			log.Printf("Triggering graceful close of all connections due to file change")
+			connManager.CloseByFilter(func(meta *proxy.ConnMeta) bool { return true })
+		})
+	})
+
+	if authenticator != nil {
+		htpasswdFile := os.Getenv("PROXY_AUTH_HTPASSWD_FILE")
+		g.Go(func() error {
+			return proxy.StartFileWatcher(gctx, htpasswdFile, func() {
+				if err := authenticator.Reload(); err != nil {
+					log.Printf("Failed to reload htpasswd file %s: %v", htpasswdFile, err)
+				}
+			})
+		})
+	}
+
+	if filterConfigFile := os.Getenv("PROXY_FILTER_CONFIG_FILE"); filterConfigFile != "" {
+		if err := loadFilterConfig(filter, filterConfigFile); err != nil {
+			log.Fatalf("Failed to load filter config %s: %v", filterConfigFile, err)
+		}
+		g.Go(func() error {
+			return proxy.StartFileWatcher(gctx, filterConfigFile, func() {
+				if err := loadFilterConfig(filter, filterConfigFile); err != nil {
+					log.Printf("Failed to reload filter config %s: %v", filterConfigFile, err)
+					return
+				}
+				// Only drop connections the new ruleset actually invalidates.
+				connManager.CloseByFilter(func(meta *proxy.ConnMeta) bool {
+					return !filter.StillAllowed(meta)
+				})
+			})
+		})
+	}
+
+	<-ctx.Done()
 	log.Println("Shutdown signal received, stopping servers gracefully...")
-	// Add graceful shutdown handling in future...
+
+	if err := g.Wait(); err != nil {
+		log.Printf("Proxy shutdown completed with error: %v", err)
+		os.Exit(1)
+	}
 
 	log.Println("Proxy shutdown complete.")
 }
 
-func startHTTPProxy(cm *proxy.ConnManager, filter *proxy.RequestFilter) {
-	go func() {
-		if err := proxy.StartHTTPServer(":80", cm, filter); err != nil {
-			log.Fatalf("HTTP proxy failed: %v", err)
-		}
-	}()
-}
+// newUpstreamPool builds an upstream.Pool from the PROXY_UPSTREAMS env var
+// (a comma-separated list of upstream URIs, e.g.
+// "socks5://user:pw@h:1080,ssh://user@h:22"). It returns a nil pool, not an
+// error, when PROXY_UPSTREAMS is unset, so origins are dialed directly.
+func newUpstreamPool() (*upstream.Pool, error) {
+	raw := os.Getenv("PROXY_UPSTREAMS")
+	if raw == "" {
+		return nil, nil
+	}
 
-func startHTTPSProxy(cm *proxy.ConnManager, filter *proxy.RequestFilter, haproxyAddr string, tlsTermination bool) {
-	go func() {
-		if err := proxy.StartHTTPSServer(":443", cm, filter, haproxyAddr, tlsTermination); err != nil {
-			log.Fatalf("HTTPS proxy failed: %v", err)
+	var upstreams []*upstream.Upstream
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
 		}
-	}()
-}
-
-func startHAProxyForwardedListener(cm *proxy.ConnManager, filter *proxy.RequestFilter) {
-	go func() {
-		if err := proxy.StartHAProxyListener(":8080", cm, filter); err != nil {
-			log.Fatalf("HAProxy forwarded listener failed: %v", err)
+		u, err := upstream.Parse(part)
+		if err != nil {
+			return nil, err
 		}
-	}()
+		upstreams = append(upstreams, u)
+	}
+	if len(upstreams) == 0 {
+		return nil, nil
+	}
+
+	strategy := upstream.Strategy(os.Getenv("PROXY_UPSTREAM_STRATEGY"))
+	if strategy == "" {
+		strategy = upstream.RoundRobin
+	}
+
+	probeURL := os.Getenv("PROXY_UPSTREAM_PROBE_URL")
+	if probeURL == "" {
+		probeURL = defaultUpstreamProbeURL
+	}
+
+	upstream.RegisterMetrics()
+	return upstream.NewPool(upstreams, strategy, probeURL, 30*time.Second), nil
 }
 
-func startMetricsEndpoint() {
-	go func() {
-		if err := proxy.StartMetricsServer(":9090"); err != nil {
-			log.Fatalf("Metrics server failed: %v", err)
-		}
-	}()
+// newMITMEngine builds a MITMEngine from PROXY_MITM_ENABLED. It returns a nil
+// engine, not an error, when that env var isn't set to "true", so a proxy
+// whose ruleset never enables MITM per-host isn't forced to provision a CA
+// just to boot.
+func newMITMEngine() (*proxy.MITMEngine, error) {
+	if os.Getenv("PROXY_MITM_ENABLED") != "true" {
+		return nil, nil
+	}
+
+	caCert := os.Getenv("PROXY_MITM_CA_CERT")
+	if caCert == "" {
+		caCert = defaultMITMCACert
+	}
+	caKey := os.Getenv("PROXY_MITM_CA_KEY")
+	if caKey == "" {
+		caKey = defaultMITMCAKey
+	}
+
+	return proxy.NewMITMEngine(caCert, caKey)
 }
 
-func startFileWatcher(cm *proxy.ConnManager) {
-	go proxy.StartFileWatcher("/tmp/proxy-trigger.txt", cm)
+// newAuthenticator builds an htpasswd-backed Authenticator from
+// PROXY_AUTH_HTPASSWD_FILE. It returns a nil authenticator, not an error,
+// when that env var is unset, so proxy auth stays opt-in.
+func newAuthenticator() (*auth.HtpasswdAuthenticator, error) {
+	htpasswdFile := os.Getenv("PROXY_AUTH_HTPASSWD_FILE")
+	if htpasswdFile == "" {
+		return nil, nil
+	}
+
+	realm := os.Getenv("PROXY_AUTH_REALM")
+	if realm == "" {
+		realm = defaultAuthRealm
+	}
+
+	authenticator, err := auth.NewHtpasswdAuthenticator(htpasswdFile, realm)
+	if err != nil {
+		return nil, err
+	}
+	authenticator.HiddenDomain = os.Getenv("PROXY_AUTH_HIDDEN_DOMAIN")
+
+	return authenticator, nil
 }
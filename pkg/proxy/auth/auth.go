@@ -0,0 +1,78 @@
+// Package auth implements proxy client authentication via the
+// Proxy-Authorization header, backed by an htpasswd file.
+package auth
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/tg123/go-htpasswd"
+)
+
+// Authenticator validates a username/password pair presented by a proxy
+// client. Basic (htpasswd-backed) auth via HtpasswdAuthenticator is the only
+// implementation; the proxy's Deps.Auth field and authenticateProxyClient
+// use that concrete type rather than this interface.
+type Authenticator interface {
+	Validate(username, password string) bool
+}
+
+// HtpasswdAuthenticator validates Proxy-Authorization credentials against
+// an Apache-style htpasswd file, in the formats htpasswd.DefaultSystems
+// accepts (bcrypt, MD5/apr1, SHA, SSHA, and crypt(3) SHA-256/512; plain-text
+// entries are not among them). The credential map is reloaded wholesale and swapped
+// in under a write lock, so Validate never observes a partially-updated
+// file.
+type HtpasswdAuthenticator struct {
+	mu   sync.RWMutex
+	file *htpasswd.File
+
+	path  string
+	Realm string // presented to clients in the Proxy-Authenticate challenge
+
+	// HiddenDomain, if set, is a hostname that when requested forces a
+	// reauth challenge (see IsReauthTrigger/WriteReauthChallenge) instead
+	// of being dialed like a normal destination.
+	HiddenDomain string
+}
+
+// NewHtpasswdAuthenticator loads path as an htpasswd file and returns an
+// Authenticator backed by it. realm is sent in the Proxy-Authenticate
+// challenge on auth failure.
+func NewHtpasswdAuthenticator(path, realm string) (*HtpasswdAuthenticator, error) {
+	a := &HtpasswdAuthenticator{path: path, Realm: realm}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Validate reports whether username/password matches an entry in the
+// htpasswd file.
+func (a *HtpasswdAuthenticator) Validate(username, password string) bool {
+	a.mu.RLock()
+	file := a.file
+	a.mu.RUnlock()
+
+	return file.Match(username, password)
+}
+
+// Reload re-reads the htpasswd file from disk and atomically swaps it in.
+// It is safe to call concurrently with Validate, and is the method
+// StartFileWatcher should call when the file changes on disk.
+func (a *HtpasswdAuthenticator) Reload() error {
+	file, err := htpasswd.New(a.path, htpasswd.DefaultSystems, func(err error) {
+		log.Printf("auth: ignoring malformed htpasswd line in %s: %v", a.path, err)
+	})
+	if err != nil {
+		return fmt.Errorf("load htpasswd file %s: %w", a.path, err)
+	}
+
+	a.mu.Lock()
+	a.file = file
+	a.mu.Unlock()
+
+	log.Printf("auth: reloaded htpasswd file %s", a.path)
+	return nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ProxyAuthorizationHeader is the request header browsers and HTTP clients
+// populate with upstream-proxy credentials. net/http's Request.BasicAuth
+// only looks at Authorization, so proxy auth needs its own parsing.
+const ProxyAuthorizationHeader = "Proxy-Authorization"
+
+// ProxyAuthenticateHeader is the challenge header sent back on a 407.
+const ProxyAuthenticateHeader = "Proxy-Authenticate"
+
+// reauthCookie is set with an already-expired epoch whenever a client hits
+// the hidden reauth-trigger domain, so the browser discards it (and, in
+// practice, the cached Basic credentials a user associates with it) and is
+// forced to re-prompt on the next request.
+const reauthCookie = "l7proxy_reauth"
+
+// ParseProxyAuthorization extracts the username/password from a
+// "Proxy-Authorization: Basic <base64>" header value. ok is false if the
+// header is missing, malformed, or uses a scheme other than Basic.
+func ParseProxyAuthorization(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if !strings.HasPrefix(header, prefix) {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+
+	user, pass, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return "", "", false
+	}
+	return user, pass, true
+}
+
+// RequireAuth writes a 407 Proxy Authentication Required response
+// challenging for Basic auth in realm.
+func RequireAuth(w http.ResponseWriter, realm string) {
+	w.Header().Set(ProxyAuthenticateHeader, `Basic realm="`+realm+`"`)
+	http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+}
+
+// IsReauthTrigger reports whether host is the configured "hidden domain"
+// that clients can hit to force their browser to forget its cached proxy
+// credentials, e.g. after an operator rotates the htpasswd file.
+func (a *HtpasswdAuthenticator) IsReauthTrigger(host string) bool {
+	return a.HiddenDomain != "" && strings.EqualFold(host, a.HiddenDomain)
+}
+
+// WriteReauthChallenge expires reauthCookie and challenges for Basic auth
+// again, so the browser drops any cached credentials and re-prompts the
+// user on its next request.
+func WriteReauthChallenge(w http.ResponseWriter, realm string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:    reauthCookie,
+		Value:   "",
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+	})
+	RequireAuth(w, realm)
+}
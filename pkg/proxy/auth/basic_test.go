@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseProxyAuthorization(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		wantUsername string
+		wantPassword string
+		wantOK       bool
+	}{
+		{
+			name:         "valid basic credentials",
+			header:       "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:s3cret")),
+			wantUsername: "alice",
+			wantPassword: "s3cret",
+			wantOK:       true,
+		},
+		{
+			name:         "empty password",
+			header:       "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:")),
+			wantUsername: "alice",
+			wantPassword: "",
+			wantOK:       true,
+		},
+		{
+			name:   "missing header",
+			header: "",
+			wantOK: false,
+		},
+		{
+			name:   "non-basic scheme",
+			header: "Bearer sometoken",
+			wantOK: false,
+		},
+		{
+			name:   "invalid base64",
+			header: "Basic not-valid-base64!!",
+			wantOK: false,
+		},
+		{
+			name:   "decoded value missing colon separator",
+			header: "Basic " + base64.StdEncoding.EncodeToString([]byte("alicesecret")),
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, pass, ok := ParseProxyAuthorization(tt.header)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !tt.wantOK {
+				return
+			}
+			if user != tt.wantUsername || pass != tt.wantPassword {
+				t.Errorf("got (%q, %q), want (%q, %q)", user, pass, tt.wantUsername, tt.wantPassword)
+			}
+		})
+	}
+}
+
+func TestIsReauthTrigger(t *testing.T) {
+	a := &HtpasswdAuthenticator{HiddenDomain: "reauth.internal"}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"reauth.internal", true},
+		{"REAUTH.internal", true}, // host matching is case-insensitive
+		{"other.internal", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := a.IsReauthTrigger(tt.host); got != tt.want {
+			t.Errorf("IsReauthTrigger(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestIsReauthTriggerDisabledWhenHiddenDomainUnset(t *testing.T) {
+	a := &HtpasswdAuthenticator{}
+
+	if a.IsReauthTrigger("anything") {
+		t.Error("expected IsReauthTrigger to always report false when HiddenDomain is unset")
+	}
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/your-username/go-l7-proxy/pkg/proxy/auth"
+)
+
+// authenticateProxyClient enforces Proxy-Authorization when deps.Auth is
+// configured. It returns the authenticated username and true on success; on
+// failure it has already written the appropriate 407 response and the
+// caller must not proceed (in particular, must not hijack the connection).
+func authenticateProxyClient(w http.ResponseWriter, r *http.Request, deps *Deps) (string, bool) {
+	if deps.Auth == nil {
+		return "", true
+	}
+
+	hostname := stripPort(r.Host)
+	if deps.Auth.IsReauthTrigger(hostname) {
+		log.Printf("auth: reauth trigger hit by %s, forcing reauth", r.RemoteAddr)
+		auth.WriteReauthChallenge(w, deps.Auth.Realm)
+		return "", false
+	}
+
+	username, password, ok := auth.ParseProxyAuthorization(r.Header.Get(auth.ProxyAuthorizationHeader))
+	if !ok || !deps.Auth.Validate(username, password) {
+		log.Printf("auth: rejected proxy auth from %s for host %s", r.RemoteAddr, r.Host)
+		auth.RequireAuth(w, deps.Auth.Realm)
+		return "", false
+	}
+
+	return username, true
+}
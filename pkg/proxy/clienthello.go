@@ -0,0 +1,453 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// TLS record/handshake constants used while parsing a raw ClientHello.
+const (
+	recordTypeHandshake   = 0x16
+	handshakeTypeClientHi = 0x01
+
+	extServerName     = 0x0000
+	extSupportedGroup = 0x000a
+	extECPointFormats = 0x000b
+	extALPN           = 0x0010
+	extSupportedVers  = 0x002b
+
+	recordHeaderLen    = 5
+	handshakeHeaderLen = 4
+)
+
+// ClientHelloInfo holds everything extracted from a TLS ClientHello that is
+// useful for filtering and fingerprinting.
+type ClientHelloInfo struct {
+	SNI           string
+	ALPNProtocols []string
+	Version       uint16
+	CipherSuites  []uint16
+	Extensions    []uint16
+	Curves        []uint16
+	PointFormats  []uint8
+	SupportedVers []uint16
+	JA3           string
+	JA3Hash       string
+}
+
+// peekingConn wraps a net.Conn and serves Read calls from a bufio.Reader that
+// has already buffered (peeked) bytes from the underlying connection. This
+// lets callers inspect the ClientHello without consuming it from the stream.
+type peekingConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekingConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// peekClientHello non-destructively reads the TLS ClientHello off conn,
+// parses it, and returns a replacement net.Conn that still sees the peeked
+// bytes on subsequent reads. The original conn must not be used afterwards.
+func peekClientHello(conn net.Conn) (net.Conn, *ClientHelloInfo, error) {
+	br := bufio.NewReaderSize(conn, 16*1024)
+
+	record, err := peekRecord(br)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := parseClientHello(record)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped := &peekingConn{Conn: conn, reader: br}
+	return wrapped, info, nil
+}
+
+// peekRecord peeks the TLS record header, then grows the peek until the full
+// handshake record is buffered. It only ever reads the single leading TLS
+// record, and nothing is reassembled: a record larger than br's peek buffer
+// is rejected here with "ClientHello larger than peek buffer", while a
+// ClientHello fragmented across multiple handshake records is returned
+// partially and then rejected by parseClientHello as "truncated ClientHello".
+func peekRecord(br *bufio.Reader) ([]byte, error) {
+	header, err := br.Peek(recordHeaderLen)
+	if err != nil {
+		return nil, fmt.Errorf("peek record header: %w", err)
+	}
+	if header[0] != recordTypeHandshake {
+		return nil, errors.New("not a TLS handshake record")
+	}
+	recordLen := int(binary.BigEndian.Uint16(header[3:5]))
+	total := recordHeaderLen + recordLen
+	if total > br.Size() {
+		return nil, errors.New("ClientHello larger than peek buffer")
+	}
+
+	data, err := br.Peek(total)
+	if err != nil {
+		return nil, fmt.Errorf("peek record body: %w", err)
+	}
+	out := make([]byte, total)
+	copy(out, data)
+	return out, nil
+}
+
+// parseClientHello parses the handshake body of a single TLS record
+// containing a ClientHello and extracts SNI, ALPN, versions, ciphers,
+// extensions, curves and point formats, computing the JA3 fingerprint.
+func parseClientHello(record []byte) (*ClientHelloInfo, error) {
+	if len(record) < recordHeaderLen+handshakeHeaderLen {
+		return nil, errors.New("record too short for handshake header")
+	}
+	body := record[recordHeaderLen:]
+
+	if body[0] != handshakeTypeClientHi {
+		return nil, errors.New("handshake is not a ClientHello")
+	}
+	hsLen := int(body[1])<<16 | int(body[2])<<8 | int(body[3])
+	body = body[handshakeHeaderLen:]
+	if len(body) < hsLen {
+		return nil, errors.New("truncated ClientHello")
+	}
+	body = body[:hsLen]
+
+	r := &byteReader{b: body}
+
+	clientVersion, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.skip(32); err != nil { // random
+		return nil, err
+	}
+
+	sessionIDLen, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.skip(int(sessionIDLen)); err != nil {
+		return nil, err
+	}
+
+	cipherSuites, err := r.uint16Vector(2)
+	if err != nil {
+		return nil, err
+	}
+
+	compressionLen, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.skip(int(compressionLen)); err != nil {
+		return nil, err
+	}
+
+	info := &ClientHelloInfo{
+		Version:      clientVersion,
+		CipherSuites: cipherSuites,
+	}
+
+	if r.remaining() == 0 {
+		// No extensions (legacy clients); still compute JA3 with empty fields.
+		info.JA3, info.JA3Hash = computeJA3(info)
+		return info, nil
+	}
+
+	extTotalLen, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	extData, err := r.take(int(extTotalLen))
+	if err != nil {
+		return nil, err
+	}
+
+	er := &byteReader{b: extData}
+	for er.remaining() > 0 {
+		extType, err := er.uint16()
+		if err != nil {
+			return nil, err
+		}
+		extLen, err := er.uint16()
+		if err != nil {
+			return nil, err
+		}
+		extBody, err := er.take(int(extLen))
+		if err != nil {
+			return nil, err
+		}
+		info.Extensions = append(info.Extensions, extType)
+
+		switch extType {
+		case extServerName:
+			sni, err := parseSNIExtension(extBody)
+			if err == nil {
+				info.SNI = sni
+			}
+		case extSupportedGroup:
+			info.Curves, _ = parseUint16List(extBody)
+		case extECPointFormats:
+			info.PointFormats, _ = parseUint8List(extBody)
+		case extALPN:
+			info.ALPNProtocols, _ = parseALPN(extBody)
+		case extSupportedVers:
+			info.SupportedVers, _ = parseSupportedVersions(extBody)
+		}
+	}
+
+	info.JA3, info.JA3Hash = computeJA3(info)
+	return info, nil
+}
+
+// parseSNIExtension parses a server_name extension body and returns the
+// first hostname entry (type 0x00).
+func parseSNIExtension(b []byte) (string, error) {
+	r := &byteReader{b: b}
+	listLen, err := r.uint16()
+	if err != nil {
+		return "", err
+	}
+	listBody, err := r.take(int(listLen))
+	if err != nil {
+		return "", err
+	}
+	lr := &byteReader{b: listBody}
+	for lr.remaining() > 0 {
+		nameType, err := lr.uint8()
+		if err != nil {
+			return "", err
+		}
+		nameLen, err := lr.uint16()
+		if err != nil {
+			return "", err
+		}
+		name, err := lr.take(int(nameLen))
+		if err != nil {
+			return "", err
+		}
+		if nameType == 0x00 {
+			return string(name), nil
+		}
+	}
+	return "", errors.New("no hostname entry in server_name extension")
+}
+
+func parseALPN(b []byte) ([]string, error) {
+	r := &byteReader{b: b}
+	listLen, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	listBody, err := r.take(int(listLen))
+	if err != nil {
+		return nil, err
+	}
+	lr := &byteReader{b: listBody}
+	var protos []string
+	for lr.remaining() > 0 {
+		n, err := lr.uint8()
+		if err != nil {
+			return nil, err
+		}
+		proto, err := lr.take(int(n))
+		if err != nil {
+			return nil, err
+		}
+		protos = append(protos, string(proto))
+	}
+	return protos, nil
+}
+
+func parseUint16List(b []byte) ([]uint16, error) {
+	r := &byteReader{b: b}
+	n, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.take(int(n))
+	if err != nil {
+		return nil, err
+	}
+	var out []uint16
+	dr := &byteReader{b: data}
+	for dr.remaining() > 0 {
+		v, err := dr.uint16()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func parseUint8List(b []byte) ([]uint8, error) {
+	r := &byteReader{b: b}
+	n, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	return r.take(int(n))
+}
+
+// parseSupportedVersions parses the TLS 1.3 supported_versions extension,
+// which (in a ClientHello) is a length-prefixed list of uint16 versions.
+func parseSupportedVersions(b []byte) ([]uint16, error) {
+	r := &byteReader{b: b}
+	n, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.take(int(n))
+	if err != nil {
+		return nil, err
+	}
+	var out []uint16
+	dr := &byteReader{b: data}
+	for dr.remaining() > 0 {
+		v, err := dr.uint16()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// computeJA3 builds the JA3 string ("Version,Ciphers,Extensions,Curves,
+// PointFormats") and its MD5 hash, per the ja3 spec (GREASE values are
+// dropped from the ciphers/extensions/curves lists). The version field is
+// the raw ClientHello client_version, not the negotiated/supported_versions
+// value: TLS 1.3 clients deliberately freeze client_version at 0x0303, and
+// JA3 hashes that field as-is so this implementation's fingerprints match
+// external JA3 tooling and databases.
+func computeJA3(info *ClientHelloInfo) (string, string) {
+	ja3 := strings.Join([]string{
+		strconv.Itoa(int(info.Version)),
+		joinUint16(filterGREASE(info.CipherSuites), "-"),
+		joinUint16(filterGREASE(info.Extensions), "-"),
+		joinUint16(filterGREASE(info.Curves), "-"),
+		joinUint8(info.PointFormats, "-"),
+	}, ",")
+
+	sum := md5.Sum([]byte(ja3))
+	return ja3, hex.EncodeToString(sum[:])
+}
+
+func isGREASE(v uint16) bool {
+	// GREASE values are of the form 0x?A?A (RFC 8701).
+	return v&0x0f0f == 0x0a0a
+}
+
+func filterGREASE(in []uint16) []uint16 {
+	out := make([]uint16, 0, len(in))
+	for _, v := range in {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func joinUint16(vals []uint16, sep string) string {
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, sep)
+}
+
+func joinUint8(vals []uint8, sep string) string {
+	parts := make([]string, len(vals))
+	for i, v := range vals {
+		parts[i] = strconv.Itoa(int(v))
+	}
+	return strings.Join(parts, sep)
+}
+
+// byteReader is a small cursor over a byte slice used by the ClientHello
+// parser; it avoids pulling in encoding/binary.Read's reflection overhead.
+type byteReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *byteReader) remaining() int {
+	return len(r.b) - r.pos
+}
+
+func (r *byteReader) uint8() (uint8, error) {
+	if r.remaining() < 1 {
+		return 0, errors.New("unexpected end of data")
+	}
+	v := r.b[r.pos]
+	r.pos++
+	return v, nil
+}
+
+func (r *byteReader) uint16() (uint16, error) {
+	if r.remaining() < 2 {
+		return 0, errors.New("unexpected end of data")
+	}
+	v := binary.BigEndian.Uint16(r.b[r.pos : r.pos+2])
+	r.pos += 2
+	return v, nil
+}
+
+func (r *byteReader) skip(n int) error {
+	if r.remaining() < n {
+		return errors.New("unexpected end of data")
+	}
+	r.pos += n
+	return nil
+}
+
+func (r *byteReader) take(n int) ([]byte, error) {
+	if r.remaining() < n {
+		return nil, errors.New("unexpected end of data")
+	}
+	out := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return out, nil
+}
+
+// uint16Vector reads a length-prefixed (lenBytes-byte length) vector of
+// uint16 values, as used for the cipher suites list.
+func (r *byteReader) uint16Vector(lenBytes int) ([]uint16, error) {
+	var n int
+	switch lenBytes {
+	case 2:
+		v, err := r.uint16()
+		if err != nil {
+			return nil, err
+		}
+		n = int(v)
+	default:
+		return nil, fmt.Errorf("unsupported length prefix size %d", lenBytes)
+	}
+	data, err := r.take(n)
+	if err != nil {
+		return nil, err
+	}
+	dr := &byteReader{b: data}
+	var out []uint16
+	for dr.remaining() > 0 {
+		v, err := dr.uint16()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+)
+
+// buildClientHello encodes a minimal but wire-accurate TLS record containing
+// a ClientHello handshake message with the given fields, for use as test
+// input to parseClientHello/peekRecord.
+func buildClientHello(t *testing.T, clientVersion uint16, ciphers []uint16, sni string, supportedVers []uint16) []byte {
+	t.Helper()
+
+	var body bytes.Buffer
+	writeUint16(&body, clientVersion)
+	body.Write(make([]byte, 32)) // random
+	body.WriteByte(0)            // session ID length
+
+	var cipherBytes bytes.Buffer
+	for _, c := range ciphers {
+		writeUint16(&cipherBytes, c)
+	}
+	writeUint16(&body, uint16(cipherBytes.Len()))
+	body.Write(cipherBytes.Bytes())
+
+	body.WriteByte(1) // compression methods length
+	body.WriteByte(0) // "null" compression
+
+	var extensions bytes.Buffer
+	if sni != "" {
+		var sniEntry bytes.Buffer
+		sniEntry.WriteByte(0x00) // name_type: host_name
+		writeUint16(&sniEntry, uint16(len(sni)))
+		sniEntry.WriteString(sni)
+
+		var sniList bytes.Buffer
+		writeUint16(&sniList, uint16(sniEntry.Len()))
+		sniList.Write(sniEntry.Bytes())
+
+		writeUint16(&extensions, extServerName)
+		writeUint16(&extensions, uint16(sniList.Len()))
+		extensions.Write(sniList.Bytes())
+	}
+	if len(supportedVers) > 0 {
+		var versList bytes.Buffer
+		versList.WriteByte(byte(len(supportedVers) * 2))
+		for _, v := range supportedVers {
+			writeUint16(&versList, v)
+		}
+
+		writeUint16(&extensions, extSupportedVers)
+		writeUint16(&extensions, uint16(versList.Len()))
+		extensions.Write(versList.Bytes())
+	}
+
+	writeUint16(&body, uint16(extensions.Len()))
+	body.Write(extensions.Bytes())
+
+	var handshake bytes.Buffer
+	handshake.WriteByte(handshakeTypeClientHi)
+	hsLen := body.Len()
+	handshake.WriteByte(byte(hsLen >> 16))
+	handshake.WriteByte(byte(hsLen >> 8))
+	handshake.WriteByte(byte(hsLen))
+	handshake.Write(body.Bytes())
+
+	var record bytes.Buffer
+	record.WriteByte(recordTypeHandshake)
+	writeUint16(&record, 0x0301) // record-layer version, distinct from client_version
+	writeUint16(&record, uint16(handshake.Len()))
+	record.Write(handshake.Bytes())
+
+	return record.Bytes()
+}
+
+func writeUint16(buf *bytes.Buffer, v uint16) {
+	buf.WriteByte(byte(v >> 8))
+	buf.WriteByte(byte(v))
+}
+
+func TestParseClientHelloExtractsSNIAndCiphers(t *testing.T) {
+	record := buildClientHello(t, 0x0303, []uint16{0xc02f, 0xc030}, "example.com", nil)
+
+	info, err := parseClientHello(record)
+	if err != nil {
+		t.Fatalf("parseClientHello: %v", err)
+	}
+	if info.SNI != "example.com" {
+		t.Errorf("SNI = %q, want %q", info.SNI, "example.com")
+	}
+	if info.Version != 0x0303 {
+		t.Errorf("Version = %#04x, want %#04x", info.Version, 0x0303)
+	}
+	if len(info.CipherSuites) != 2 || info.CipherSuites[0] != 0xc02f || info.CipherSuites[1] != 0xc030 {
+		t.Errorf("CipherSuites = %v, want [0xc02f 0xc030]", info.CipherSuites)
+	}
+	if info.JA3 == "" || info.JA3Hash == "" {
+		t.Error("expected JA3 and JA3Hash to be populated")
+	}
+}
+
+func TestParseClientHelloRejectsNonClientHelloHandshake(t *testing.T) {
+	record := buildClientHello(t, 0x0303, []uint16{0xc02f}, "", nil)
+	record[recordHeaderLen] = 0x02 // ServerHello, not ClientHello
+
+	if _, err := parseClientHello(record); err == nil {
+		t.Error("expected an error for a non-ClientHello handshake message")
+	}
+}
+
+func TestPeekRecordRejectsNonHandshakeRecordType(t *testing.T) {
+	record := buildClientHello(t, 0x0303, []uint16{0xc02f}, "", nil)
+	record[0] = 0x17 // application_data, not handshake
+	br := bufio.NewReaderSize(bytes.NewReader(record), 16*1024)
+
+	if _, err := peekRecord(br); err == nil {
+		t.Error("expected an error for a non-handshake record type")
+	}
+}
+
+func TestComputeJA3UsesRawClientVersionNotSupportedVersions(t *testing.T) {
+	// A TLS 1.3 client freezes client_version at 0x0303 (TLS 1.2) and
+	// negotiates the real version via supported_versions (0x0304). The
+	// canonical JA3 algorithm hashes the raw client_version, so the two
+	// must not be conflated here.
+	record := buildClientHello(t, 0x0303, []uint16{0xc02f}, "", []uint16{0x0304, 0x0303})
+
+	info, err := parseClientHello(record)
+	if err != nil {
+		t.Fatalf("parseClientHello: %v", err)
+	}
+
+	wantJA3 := "771,49199,43,,"
+	if info.JA3 != wantJA3 {
+		t.Errorf("JA3 = %q, want %q", info.JA3, wantJA3)
+	}
+	sum := md5.Sum([]byte(wantJA3))
+	if info.JA3Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("JA3Hash = %q, want md5(%q)", info.JA3Hash, wantJA3)
+	}
+}
+
+func TestFilterGREASEDropsGREASEValues(t *testing.T) {
+	in := []uint16{0x0a0a, 0xc02f, 0x1a1a, 0xc030}
+	got := filterGREASE(in)
+	want := []uint16{0xc02f, 0xc030}
+	if len(got) != len(want) {
+		t.Fatalf("filterGREASE(%v) = %v, want %v", in, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("filterGREASE(%v)[%d] = %#04x, want %#04x", in, i, got[i], want[i])
+		}
+	}
+}
+
+func TestPeekRecordRejectsOversizedClientHello(t *testing.T) {
+	record := buildClientHello(t, 0x0303, []uint16{0xc02f, 0xc030, 0xc013, 0xc014}, "example.com", nil)
+	br := bufio.NewReaderSize(bytes.NewReader(record), len(record)-1)
+
+	if _, err := peekRecord(br); err == nil {
+		t.Error("expected an error when the record doesn't fit in the peek buffer")
+	}
+}
+
+func TestPeekRecordReturnsFullRecord(t *testing.T) {
+	record := buildClientHello(t, 0x0303, []uint16{0xc02f}, "example.com", nil)
+	br := bufio.NewReaderSize(bytes.NewReader(record), 16*1024)
+
+	got, err := peekRecord(br)
+	if err != nil {
+		t.Fatalf("peekRecord: %v", err)
+	}
+	if !bytes.Equal(got, record) {
+		t.Errorf("peekRecord returned %d bytes, want the full %d-byte record", len(got), len(record))
+	}
+}
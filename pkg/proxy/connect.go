@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-username/go-l7-proxy/pkg/proxy/mitm"
+)
+
+// MITMEngine bundles the CA used to mint leaf certificates for MITM'd
+// CONNECT tunnels with the interceptor chain that inspects decrypted
+// requests/responses flowing through them.
+type MITMEngine struct {
+	CAStore      *mitm.CAStore
+	Interceptors *mitm.InterceptorChain
+}
+
+// NewMITMEngine loads (or generates, on first boot) the CA at
+// caCertPath/caKeyPath and returns a ready-to-use MITM engine with an empty
+// interceptor chain.
+func NewMITMEngine(caCertPath, caKeyPath string) (*MITMEngine, error) {
+	store, err := mitm.NewCAStore(caCertPath, caKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &MITMEngine{CAStore: store, Interceptors: &mitm.InterceptorChain{}}, nil
+}
+
+// handleConnect services the HTTP CONNECT verb used by browsers to tunnel
+// HTTPS through the proxy. Hosts marked for MITM in the filter are
+// terminated and re-encrypted in-process; everything else falls through to
+// a raw TCP splice, as today.
+func handleConnect(w http.ResponseWriter, r *http.Request, deps *Deps) {
+	hostname := stripPort(r.Host)
+
+	username, ok := authenticateProxyClient(w, r, deps)
+	if !ok {
+		return
+	}
+
+	if !deps.Filter.AllowHTTP(hostname, "", stripPort(r.RemoteAddr)) {
+		http.Error(w, "Blocked by proxy filter", http.StatusForbidden)
+		log.Printf("CONNECT blocked by filter host=%s", r.Host)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, "Hijacking failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		log.Printf("CONNECT: failed to write 200 to client for %s: %v", r.Host, err)
+		clientConn.Close()
+		return
+	}
+
+	if deps.MITMEngine != nil && deps.Filter.MITMEnabled(hostname, stripPort(r.RemoteAddr)) {
+		handleMITMTunnel(clientConn, hostname, username, deps)
+		return
+	}
+
+	handleRawConnectSplice(clientConn, r.Host, username, deps)
+}
+
+// handleRawConnectSplice tunnels the CONNECT'd connection straight to the
+// destination without inspecting the encrypted bytes.
+func handleRawConnectSplice(clientConn net.Conn, addr, username string, deps *Deps) {
+	defer clientConn.Close()
+
+	pool := deps.UpstreamPool
+	if deps.Filter.BypassUpstream(stripPort(addr), "", stripPort(clientConn.RemoteAddr().String())) {
+		pool = nil
+	}
+	destConn, err := dialOutTimeout(pool, "tcp", addr, clientConn.RemoteAddr().String(), 10*time.Second)
+	if err != nil {
+		log.Printf("CONNECT: failed to dial destination %s: %v", addr, err)
+		return
+	}
+	defer destConn.Close()
+
+	meta := ConnMeta{
+		ClientAddr: clientConn.RemoteAddr().String(),
+		ServerAddr: destConn.RemoteAddr().String(),
+		Hostname:   addr,
+		Username:   username,
+		Protocol:   "https_connect_passthrough",
+		CreatedAt:  time.Now(),
+	}
+	id := deps.ConnManager.Add(clientConn, destConn, meta)
+	log.Printf("Tracking CONNECT passthrough id=%s host=%s", id, addr)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go proxyCopy(&wg, destConn, clientConn)
+	go proxyCopy(&wg, clientConn, destConn)
+	wg.Wait()
+
+	deps.ConnManager.Remove(id)
+	log.Printf("Closed CONNECT passthrough id=%s", id)
+}
+
+// handleMITMTunnel terminates TLS toward the client with a leaf certificate
+// minted for hostname, dials the real upstream over validated TLS, and
+// shuttles decrypted request/response pairs through the interceptor chain.
+func handleMITMTunnel(clientConn net.Conn, hostname, username string, deps *Deps) {
+	defer clientConn.Close()
+
+	tlsClientConn := tls.Server(clientConn, &tls.Config{
+		GetCertificate: deps.MITMEngine.CAStore.GetCertificate,
+	})
+	if err := tlsClientConn.Handshake(); err != nil {
+		log.Printf("MITM: TLS handshake with client failed for %s: %v", hostname, err)
+		return
+	}
+	defer tlsClientConn.Close()
+
+	pool := deps.UpstreamPool
+	if deps.Filter.BypassUpstream(hostname, "", stripPort(clientConn.RemoteAddr().String())) {
+		pool = nil
+	}
+	rawUpstreamConn, err := dialOutTimeout(pool, "tcp", hostname+":443", clientConn.RemoteAddr().String(), 10*time.Second)
+	if err != nil {
+		log.Printf("MITM: failed to dial upstream %s: %v", hostname, err)
+		return
+	}
+	upstreamConn := tls.Client(rawUpstreamConn, &tls.Config{ServerName: hostname})
+	if err := upstreamConn.Handshake(); err != nil {
+		log.Printf("MITM: TLS handshake with upstream %s failed: %v", hostname, err)
+		rawUpstreamConn.Close()
+		return
+	}
+	defer upstreamConn.Close()
+
+	meta := ConnMeta{
+		ClientAddr: clientConn.RemoteAddr().String(),
+		ServerAddr: upstreamConn.RemoteAddr().String(),
+		Hostname:   hostname,
+		SNI:        hostname,
+		Username:   username,
+		Protocol:   "https_mitm",
+		CreatedAt:  time.Now(),
+	}
+	id := deps.ConnManager.Add(clientConn, upstreamConn, meta)
+	log.Printf("Tracking MITM tunnel id=%s host=%s", id, hostname)
+	defer func() {
+		deps.ConnManager.Remove(id)
+		log.Printf("Closed MITM tunnel id=%s host=%s", id, hostname)
+	}()
+
+	clientReader := bufio.NewReader(tlsClientConn)
+	upstreamReader := bufio.NewReader(upstreamConn)
+
+	for {
+		req, err := http.ReadRequest(clientReader)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("MITM: reading request from client failed for %s: %v", hostname, err)
+			}
+			return
+		}
+		req.URL.Scheme = "https"
+		req.URL.Host = req.Host
+
+		req, shortCircuit := deps.MITMEngine.Interceptors.RunRequest(req)
+		if shortCircuit != nil {
+			if err := shortCircuit.Write(tlsClientConn); err != nil {
+				log.Printf("MITM: writing intercepted response to client failed for %s: %v", hostname, err)
+				return
+			}
+			continue
+		}
+
+		if err := req.Write(upstreamConn); err != nil {
+			log.Printf("MITM: forwarding request to upstream %s failed: %v", hostname, err)
+			return
+		}
+
+		resp, err := http.ReadResponse(upstreamReader, req)
+		if err != nil {
+			log.Printf("MITM: reading response from upstream %s failed: %v", hostname, err)
+			return
+		}
+
+		resp = deps.MITMEngine.Interceptors.RunResponse(resp, req)
+
+		if err := resp.Write(tlsClientConn); err != nil {
+			log.Printf("MITM: writing response to client failed for %s: %v", hostname, err)
+			resp.Body.Close()
+			return
+		}
+		resp.Body.Close()
+
+		if req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+// stripPort returns host without a trailing ":port", if present.
+func stripPort(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.ToLower(hostport)
+}
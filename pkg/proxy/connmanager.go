@@ -3,6 +3,7 @@ package proxy
 import (
 	"fmt"
 	"log"
+	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,6 +17,8 @@ type ConnMeta struct {
 	ServerAddr  string
 	Hostname    string
 	SNI         string
+	JA3         string // JA3 fingerprint hash of the client's TLS ClientHello, if any
+	Username    string // authenticated proxy user, if proxy auth is enabled
 	Protocol    string // e.g. "http", "https_tls_passthrough" etc.
 	HAProxyAddr string // Loopback HAProxy address if TLS termination mode
 	CreatedAt   time.Time
@@ -63,6 +66,10 @@ func (m *ConnManager) Add(client, server net.Conn, meta ConnMeta) string {
 		atomic.AddInt64(&metrics.ProxyHAProxyConns, 1)
 	}
 
+	if meta.JA3 != "" {
+		proxyMetrics.JA3Fingerprints.WithLabelValues(meta.JA3).Inc()
+	}
+
 	log.Printf("Added connection %s: client %s - server %s - protocol %s", meta.ID, meta.ClientAddr, meta.ServerAddr, meta.Protocol)
 
 	return meta.ID
@@ -93,14 +100,21 @@ func (m *ConnManager) Remove(id string) {
 	log.Printf("Removed connection %s: client %s - server %s", id, pc.Meta.ClientAddr, pc.Meta.ServerAddr)
 }
 
-// CloseByFilter closes connections matching a filter function
+// CloseByFilter closes connections matching a filter function. The
+// Connection: close hint is only written for plain-HTTP connections, same as
+// SignalClose: every other protocol's socket carries an encrypted or
+// tunneled byte stream that a concurrent proxyCopy goroutine is actively
+// relaying, and splicing a literal HTTP response into it would corrupt the
+// stream instead of gracefully closing it.
 func (m *ConnManager) CloseByFilter(filter func(meta *ConnMeta) bool) {
 	m.conns.Range(func(key, value interface{}) bool {
 		pc := value.(*ProxyConnection)
 		if filter(&pc.Meta) {
 			log.Printf("Closing connection %s client %s server %s", pc.Meta.ID, pc.Meta.ClientAddr, pc.Meta.ServerAddr)
-			sendHTTPConnectionClose(pc.Client)
-			sendHTTPConnectionClose(pc.Server)
+			if pc.Meta.Protocol == "http" {
+				sendHTTPConnectionClose(pc.Client)
+				sendHTTPConnectionClose(pc.Server)
+			}
 			pc.Client.Close()
 			pc.Server.Close()
 			m.conns.Delete(key)
@@ -109,6 +123,39 @@ func (m *ConnManager) CloseByFilter(filter func(meta *ConnMeta) bool) {
 	})
 }
 
+// SignalClose sends a "Connection: close" hint to the client side of every
+// tracked plain-HTTP connection without closing the underlying sockets,
+// giving well-behaved peers a chance to finish on their own during drain.
+// It deliberately skips every other protocol (TLS passthrough/termination,
+// CONNECT tunnels, MITM): those sockets carry an encrypted or already
+// tunneled byte stream that other goroutines are actively relaying, and
+// splicing a literal HTTP response into it would corrupt the stream instead
+// of gracefully closing it.
+func (m *ConnManager) SignalClose() {
+	m.conns.Range(func(key, value interface{}) bool {
+		pc := value.(*ProxyConnection)
+		if pc.Meta.Protocol == "http" {
+			sendHTTPConnectionClose(pc.Client)
+		}
+		return true
+	})
+}
+
+// ForceCloseAll immediately closes every tracked connection.
+func (m *ConnManager) ForceCloseAll() {
+	m.CloseByFilter(func(meta *ConnMeta) bool { return true })
+}
+
+// Count returns the number of currently tracked connections.
+func (m *ConnManager) Count() int {
+	count := 0
+	m.conns.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return count
+}
+
 // Stats returns a snapshot of current connections
 func (m *ConnManager) Stats() []ConnMeta {
 	conns := make([]ConnMeta, 0)
@@ -141,4 +188,3 @@ func sendHTTPConnectionClose(conn net.Conn) {
 	}
 	_ = conn.SetWriteDeadline(time.Time{})
 }
-
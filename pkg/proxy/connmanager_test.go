@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn is a minimal net.Conn that records what's written to it instead
+// of requiring a live peer, so tests can assert on writes without the
+// rendezvous timing net.Pipe would otherwise require.
+type fakeConn struct {
+	mu     sync.Mutex
+	writes [][]byte
+	closed bool
+}
+
+func (c *fakeConn) Read(b []byte) (int, error) { return 0, nil }
+func (c *fakeConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp := append([]byte(nil), b...)
+	c.writes = append(c.writes, cp)
+	return len(b), nil
+}
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+func (c *fakeConn) LocalAddr() net.Addr                { return nil }
+func (c *fakeConn) RemoteAddr() net.Addr               { return nil }
+func (c *fakeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fakeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *fakeConn) wroteAnything() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.writes) > 0
+}
+
+func (c *fakeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// TestCloseByFilterSkipsConnectionCloseForNonHTTPProtocols reproduces the
+// scenario from main.go's filter-config hot reload: a live connection is
+// closed because the newly reloaded ruleset no longer allows it
+// (cm.CloseByFilter(func(meta) bool { return !filter.StillAllowed(meta) })).
+// Only plain-HTTP connections should have the literal HTTP "Connection:
+// close" response spliced into their client/server sockets; every other
+// protocol's socket is being actively relayed by a proxyCopy goroutine and
+// must only be closed, not written to.
+func TestCloseByFilterSkipsConnectionCloseForNonHTTPProtocols(t *testing.T) {
+	cases := []struct {
+		protocol string
+		wantSent bool
+	}{
+		{"http", true},
+		{"https_tls_passthrough", false},
+		{"https_tls_termination", false},
+		{"https_connect_passthrough", false},
+		{"https_mitm", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.protocol, func(t *testing.T) {
+			cm := NewConnManager()
+			client := &fakeConn{}
+			server := &fakeConn{}
+
+			cm.Add(client, server, ConnMeta{
+				ClientAddr: "client:1",
+				ServerAddr: "server:1",
+				Hostname:   "blocked.example.com",
+				Protocol:   tc.protocol,
+			})
+
+			// Simulates a filter reload invalidating this session.
+			cm.CloseByFilter(func(meta *ConnMeta) bool { return meta.Hostname == "blocked.example.com" })
+
+			if !client.isClosed() || !server.isClosed() {
+				t.Errorf("protocol %q: expected both sides of the connection to be closed", tc.protocol)
+			}
+			if gotSent := client.wroteAnything() || server.wroteAnything(); gotSent != tc.wantSent {
+				t.Errorf("protocol %q: wrote bytes to sockets = %v, want %v", tc.protocol, gotSent, tc.wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package proxy
+
+import (
+	"github.com/your-username/go-l7-proxy/pkg/proxy/auth"
+	"github.com/your-username/go-l7-proxy/pkg/proxy/upstream"
+)
+
+// Deps bundles the shared collaborators every connection handler needs, so
+// adding a new one doesn't mean growing every handler's parameter list.
+type Deps struct {
+	ConnManager  *ConnManager
+	Filter       *RequestFilter
+	MITMEngine   *MITMEngine                 // nil disables MITM; CONNECT tunnels always pass through raw
+	UpstreamPool *upstream.Pool              // nil dials origins directly instead of chaining through a parent proxy
+	Auth         *auth.HtpasswdAuthenticator // nil disables proxy authentication
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/your-username/go-l7-proxy/pkg/proxy/upstream"
+)
+
+// dialOut dials addr, routing through pool (by clientIP, for sticky
+// strategies) if one is configured, or directly to the origin otherwise.
+// clientIP may carry a ":port" suffix; it is stripped before the pool sees it.
+func dialOut(ctx context.Context, pool *upstream.Pool, network, addr, clientIP string) (net.Conn, error) {
+	if pool != nil {
+		return pool.Dial(ctx, network, addr, stripPort(clientIP))
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// dialOutTimeout is a convenience wrapper around dialOut for call sites that
+// haven't been threaded onto a context yet.
+func dialOutTimeout(pool *upstream.Pool, network, addr, clientIP string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return dialOut(ctx, pool, network, addr, clientIP)
+}
@@ -1,85 +1,249 @@
 package proxy
 
 import (
+	"fmt"
 	"log"
+	"net"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 )
 
-// RequestFilter holds hostname and SNI allowlists for filtering
+// Action is the outcome a matching rule attaches to a connection.
+type Action string
+
+const (
+	ActionAllow          Action = "allow"
+	ActionDeny           Action = "deny"
+	ActionMITM           Action = "mitm"
+	ActionBypassUpstream Action = "bypass-upstream"
+)
+
+// Decision is the result of matching a connection against the filter's
+// ruleset: the action to take, and (for logging/debugging) which rule
+// produced it.
+type Decision struct {
+	Action Action
+	Rule   string // human-readable description of the matching rule, "" if no rule matched
+}
+
+// Allowed reports whether the decision's action permits the connection to
+// proceed at all (mitm and bypass-upstream both imply allow).
+func (d Decision) Allowed() bool {
+	return d.Action == ActionAllow || d.Action == ActionMITM || d.Action == ActionBypassUpstream
+}
+
+// rule is a compiled RuleConfig: patterns are parsed into matchers once at
+// load time so Match doesn't recompile anything per request.
+type rule struct {
+	desc string
+
+	host         string         // exact hostname, lowercased, "" if unused
+	hostWildcard string         // "*.example.com" style suffix match, "" if unused
+	hostRegex    *regexp.Regexp // nil if unused
+
+	pathPrefix string         // "" if unused
+	pathRegex  *regexp.Regexp // nil if unused
+
+	clientCIDR *net.IPNet // nil if unused
+
+	action Action
+}
+
+// matchesHost reports whether target (an HTTP Host or TLS SNI, whichever
+// the caller has) satisfies the rule's host criteria. A rule with no host
+// criteria matches any host.
+func (r *rule) matchesHost(target string) bool {
+	if r.host == "" && r.hostWildcard == "" && r.hostRegex == nil {
+		return true
+	}
+	if target == "" {
+		return false
+	}
+	target = strings.ToLower(target)
+
+	if r.host != "" && r.host == target {
+		return true
+	}
+	if r.hostWildcard != "" && strings.HasSuffix(target, r.hostWildcard) {
+		return true
+	}
+	if r.hostRegex != nil && r.hostRegex.MatchString(target) {
+		return true
+	}
+	return false
+}
+
+// matchesPath reports whether path satisfies the rule's path criteria. A
+// rule with no path criteria matches any path.
+func (r *rule) matchesPath(path string) bool {
+	if r.pathPrefix == "" && r.pathRegex == nil {
+		return true
+	}
+	if r.pathPrefix != "" && strings.HasPrefix(path, r.pathPrefix) {
+		return true
+	}
+	if r.pathRegex != nil && r.pathRegex.MatchString(path) {
+		return true
+	}
+	return false
+}
+
+// matchesClientIP reports whether clientIP satisfies the rule's CIDR
+// criterion. A rule with no CIDR criterion matches any client.
+func (r *rule) matchesClientIP(clientIP string) bool {
+	if r.clientCIDR == nil {
+		return true
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	return r.clientCIDR.Contains(ip)
+}
+
+// RequestFilter decides whether connections are allowed, MITM'd, or routed
+// around the upstream pool, based on a ruleset that can be hot-reloaded
+// from a YAML or JSON config file, plus the (older, narrower) JA3
+// allow/deny lists used alongside it.
 type RequestFilter struct {
 	mu          sync.RWMutex
-	allowedHosts map[string]struct{}
-	allowedSNIs  map[string]struct{}
+	rules       []*rule
+	allowedJA3s map[string]struct{} // empty means "no JA3 allowlist restriction"
+	deniedJA3s  map[string]struct{}
 	lastReload  time.Time
 }
 
-// NewRequestFilter creates a new instance with default allowed hosts and SNIs
+// NewRequestFilter creates a filter with a default allow rule for a couple
+// of well-known hosts, matching the repo's previous out-of-the-box
+// behavior. Real deployments are expected to load a ruleset via
+// ReloadRules/file watcher.
 func NewRequestFilter() *RequestFilter {
 	return &RequestFilter{
-		allowedHosts: map[string]struct{}{
-			"example.com": {},
-			"www.google.com": {},
-		},
-		allowedSNIs: map[string]struct{}{
-			"example.com": {},
-			"www.google.com": {},
+		rules: []*rule{
+			{desc: "default:example.com", host: "example.com", action: ActionAllow},
+			{desc: "default:www.google.com", host: "www.google.com", action: ActionAllow},
 		},
+		allowedJA3s: map[string]struct{}{},
+		deniedJA3s:  map[string]struct{}{},
 	}
 }
 
-// AllowHTTP returns true if the host and path are allowed by the filter
-func (f *RequestFilter) AllowHTTP(host, path string) bool {
+// Match returns the Decision for a connection identified by host (HTTP
+// Host header or CONNECT target), path (HTTP request path, "" for
+// non-HTTP), clientIP (no port), and sni (TLS SNI, "" for plain HTTP).
+// Rules are evaluated in config order; the first rule whose host, path,
+// and client-IP criteria all match wins. If no rule matches, the
+// connection is denied.
+func (f *RequestFilter) Match(host, path, clientIP, sni string) Decision {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
-	
-	// Normalize to lowercase
-	host = strings.ToLower(host)
-	
-	// Direct match allowed hosts
-	if _, ok := f.allowedHosts[host]; ok {
-		return true
+
+	target := host
+	if target == "" {
+		target = sni
 	}
-	return false
+
+	for _, r := range f.rules {
+		if r.matchesHost(target) && r.matchesPath(path) && r.matchesClientIP(clientIP) {
+			return Decision{Action: r.action, Rule: r.desc}
+		}
+	}
+	return Decision{Action: ActionDeny}
+}
+
+// AllowHTTP returns true if the host, path, and client IP are allowed by
+// the filter.
+func (f *RequestFilter) AllowHTTP(host, path, clientIP string) bool {
+	return f.Match(host, path, clientIP, "").Allowed()
 }
 
-// AllowSNI returns true if the SNI hostname is allowed
-func (f *RequestFilter) AllowSNI(sni string) bool {
+// AllowSNI returns true if the SNI hostname and client IP are allowed by
+// the ruleset and the JA3 fingerprint (may be empty if unavailable) is
+// neither denied nor excluded by a non-empty JA3 allowlist.
+func (f *RequestFilter) AllowSNI(sni, clientIP, ja3 string) bool {
+	if !f.Match("", "", clientIP, sni).Allowed() {
+		return false
+	}
+
 	f.mu.RLock()
 	defer f.mu.RUnlock()
-	
-	// Normalize
-	sni = strings.ToLower(sni)
-	
-	if _, ok := f.allowedSNIs[sni]; ok {
+
+	if ja3 == "" {
 		return true
 	}
-	return false
+	if _, denied := f.deniedJA3s[ja3]; denied {
+		return false
+	}
+	if len(f.allowedJA3s) > 0 {
+		_, ok := f.allowedJA3s[ja3]
+		return ok
+	}
+	return true
 }
 
-// Reload allows reloading allowed hosts and SNIs from config (e.g., JSON or file content)
-func (f *RequestFilter) Reload(hosts []string, snis []string) error {
+// MITMEnabled returns true if CONNECT tunnels to host should be intercepted
+// by the in-process MITM engine instead of passed through as a raw TCP
+// splice.
+func (f *RequestFilter) MITMEnabled(host, clientIP string) bool {
+	return f.Match(host, "", clientIP, "").Action == ActionMITM
+}
+
+// BypassUpstream returns true if connections to host should dial the
+// origin directly instead of being chained through the upstream pool.
+func (f *RequestFilter) BypassUpstream(host, path, clientIP string) bool {
+	return f.Match(host, path, clientIP, "").Action == ActionBypassUpstream
+}
+
+// StillAllowed re-evaluates the current ruleset against a live connection's
+// metadata, for use as a CloseByFilter predicate after a reload: it
+// targets only the sessions the new ruleset actually invalidates instead
+// of closing everything.
+func (f *RequestFilter) StillAllowed(meta *ConnMeta) bool {
+	clientIP := stripPort(meta.ClientAddr)
+	return f.Match(stripPort(meta.Hostname), "", clientIP, meta.SNI).Allowed()
+}
+
+// ReloadRules replaces the compiled ruleset from a parsed FilterConfig.
+// Existing connections are untouched; callers that need to drop sessions
+// the new ruleset invalidates should follow up with
+// cm.CloseByFilter(filter.StillAllowed) negated, i.e. close where
+// StillAllowed is false.
+func (f *RequestFilter) ReloadRules(cfg FilterConfig) error {
+	compiled, err := compileRules(cfg.Rules)
+	if err != nil {
+		return fmt.Errorf("compile filter rules: %w", err)
+	}
+
+	f.mu.Lock()
+	f.rules = compiled
+	f.lastReload = time.Now()
+	f.mu.Unlock()
+
+	log.Printf("Reloaded filter ruleset: %d rules", len(compiled))
+	return nil
+}
+
+// ReloadJA3 replaces the JA3 allow/deny lists (e.g. read from a file
+// watched by StartFileWatcher). An empty allow list means "allow any
+// fingerprint not explicitly denied".
+func (f *RequestFilter) ReloadJA3(allow, deny []string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	
-	// Clear existing maps
-	f.allowedHosts = map[string]struct{}{}
-	f.allowedSNIs = map[string]struct{}{}
 
-	// Reload allowed hosts
-	for _, h := range hosts {
-		f.allowedHosts[strings.ToLower(h)] = struct{}{}
-	}
+	f.allowedJA3s = map[string]struct{}{}
+	f.deniedJA3s = map[string]struct{}{}
 
-	// Reload allowed SNIs
-	for _, s := range snis {
-		f.allowedSNIs[strings.ToLower(s)] = struct{}{}
+	for _, h := range allow {
+		f.allowedJA3s[strings.ToLower(h)] = struct{}{}
+	}
+	for _, h := range deny {
+		f.deniedJA3s[strings.ToLower(h)] = struct{}{}
 	}
 
 	f.lastReload = time.Now()
 
-	log.Printf("Reloaded filter: %d allowed hosts, %d allowed SNIs", len(f.allowedHosts), len(f.allowedSNIs))
+	log.Printf("Reloaded JA3 filter: %d allowed fingerprints, %d denied fingerprints", len(f.allowedJA3s), len(f.deniedJA3s))
 	return nil
 }
-
@@ -0,0 +1,150 @@
+package proxy
+
+import "testing"
+
+func newTestFilter(t *testing.T, rules []RuleConfig) *RequestFilter {
+	t.Helper()
+	f := NewRequestFilter()
+	if err := f.ReloadRules(FilterConfig{Rules: rules}); err != nil {
+		t.Fatalf("ReloadRules: %v", err)
+	}
+	return f
+}
+
+func TestRequestFilterMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      []RuleConfig
+		host       string
+		path       string
+		clientIP   string
+		wantAction Action
+	}{
+		{
+			name:       "exact host match",
+			rules:      []RuleConfig{{Host: "example.com", Action: ActionAllow}},
+			host:       "example.com",
+			wantAction: ActionAllow,
+		},
+		{
+			name:       "exact host mismatch denies",
+			rules:      []RuleConfig{{Host: "example.com", Action: ActionAllow}},
+			host:       "evil.example.com",
+			wantAction: ActionDeny,
+		},
+		{
+			name:       "wildcard host match",
+			rules:      []RuleConfig{{HostWildcard: "*.example.com", Action: ActionAllow}},
+			host:       "api.example.com",
+			wantAction: ActionAllow,
+		},
+		{
+			name:       "wildcard host does not match bare domain",
+			rules:      []RuleConfig{{HostWildcard: "*.example.com", Action: ActionAllow}},
+			host:       "example.com",
+			wantAction: ActionDeny,
+		},
+		{
+			name:       "regex host match",
+			rules:      []RuleConfig{{HostRegex: `^(foo|bar)\.test$`, Action: ActionMITM}},
+			host:       "bar.test",
+			wantAction: ActionMITM,
+		},
+		{
+			name: "path prefix applies within a host",
+			rules: []RuleConfig{
+				{Host: "example.com", PathPrefix: "/admin", Action: ActionDeny},
+				{Host: "example.com", Action: ActionAllow},
+			},
+			host:       "example.com",
+			path:       "/admin/users",
+			wantAction: ActionDeny,
+		},
+		{
+			name: "path prefix miss falls through to next rule",
+			rules: []RuleConfig{
+				{Host: "example.com", PathPrefix: "/admin", Action: ActionDeny},
+				{Host: "example.com", Action: ActionAllow},
+			},
+			host:       "example.com",
+			path:       "/public",
+			wantAction: ActionAllow,
+		},
+		{
+			name:       "client CIDR match",
+			rules:      []RuleConfig{{ClientCIDR: "10.0.0.0/8", Action: ActionBypassUpstream}},
+			host:       "anything",
+			clientIP:   "10.1.2.3",
+			wantAction: ActionBypassUpstream,
+		},
+		{
+			name:       "client CIDR mismatch denies",
+			rules:      []RuleConfig{{ClientCIDR: "10.0.0.0/8", Action: ActionBypassUpstream}},
+			host:       "anything",
+			clientIP:   "192.168.1.1",
+			wantAction: ActionDeny,
+		},
+		{
+			name:       "no rules match denies by default",
+			rules:      []RuleConfig{{Host: "example.com", Action: ActionAllow}},
+			host:       "other.com",
+			wantAction: ActionDeny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFilter(t, tt.rules)
+			got := f.Match(tt.host, tt.path, tt.clientIP, "")
+			if got.Action != tt.wantAction {
+				t.Errorf("Match(%q, %q, %q) = %q, want %q", tt.host, tt.path, tt.clientIP, got.Action, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestRequestFilterAllowSNIWithJA3Lists(t *testing.T) {
+	f := newTestFilter(t, []RuleConfig{{Host: "example.com", Action: ActionAllow}})
+
+	if !f.AllowSNI("example.com", "", "ja3-unknown") {
+		t.Fatal("expected unknown JA3 to be allowed when no lists are configured")
+	}
+
+	if err := f.ReloadJA3([]string{"ja3-good"}, []string{"ja3-bad"}); err != nil {
+		t.Fatalf("ReloadJA3: %v", err)
+	}
+
+	if !f.AllowSNI("example.com", "", "ja3-good") {
+		t.Error("expected allow-listed JA3 to be allowed")
+	}
+	if f.AllowSNI("example.com", "", "ja3-bad") {
+		t.Error("expected deny-listed JA3 to be denied even though host is allowed")
+	}
+	if f.AllowSNI("example.com", "", "ja3-other") {
+		t.Error("expected JA3 outside a non-empty allowlist to be denied")
+	}
+	if !f.AllowSNI("example.com", "", "") {
+		t.Error("expected missing JA3 (unavailable) to be allowed")
+	}
+}
+
+func TestRequestFilterMITMEnabledAndBypassUpstream(t *testing.T) {
+	f := newTestFilter(t, []RuleConfig{
+		{Host: "mitm.example.com", Action: ActionMITM},
+		{Host: "direct.example.com", Action: ActionBypassUpstream},
+		{Host: "plain.example.com", Action: ActionAllow},
+	})
+
+	if !f.MITMEnabled("mitm.example.com", "") {
+		t.Error("expected mitm.example.com to have MITM enabled")
+	}
+	if f.MITMEnabled("plain.example.com", "") {
+		t.Error("expected plain.example.com to not have MITM enabled")
+	}
+	if !f.BypassUpstream("direct.example.com", "", "") {
+		t.Error("expected direct.example.com to bypass the upstream pool")
+	}
+	if f.BypassUpstream("mitm.example.com", "", "") {
+		t.Error("expected mitm.example.com to not bypass the upstream pool")
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FilterConfig is the on-disk shape of a RequestFilter ruleset, loaded from
+// YAML or JSON via ParseFilterConfig.
+type FilterConfig struct {
+	Rules []RuleConfig `yaml:"rules" json:"rules"`
+}
+
+// RuleConfig describes one rule. Exactly the criteria that are set are
+// evaluated; unset criteria match anything. If more than one of
+// Host/HostWildcard/HostRegex is set, a host matching any one of them
+// satisfies the rule's host criteria.
+type RuleConfig struct {
+	Host         string `yaml:"host,omitempty" json:"host,omitempty"`
+	HostWildcard string `yaml:"host_wildcard,omitempty" json:"host_wildcard,omitempty"` // e.g. "*.example.com"
+	HostRegex    string `yaml:"host_regex,omitempty" json:"host_regex,omitempty"`
+
+	PathPrefix string `yaml:"path_prefix,omitempty" json:"path_prefix,omitempty"`
+	PathRegex  string `yaml:"path_regex,omitempty" json:"path_regex,omitempty"`
+
+	ClientCIDR string `yaml:"client_cidr,omitempty" json:"client_cidr,omitempty"`
+
+	Action Action `yaml:"action" json:"action"`
+}
+
+// ParseFilterConfig parses data as JSON if it looks like a JSON document
+// (starts with '{' or '['), and as YAML otherwise. This lets one config
+// loader serve both formats, since hand-written filter configs in this
+// project are more often YAML but JSON is handy for generated ones.
+func ParseFilterConfig(data []byte) (FilterConfig, error) {
+	var cfg FilterConfig
+
+	trimmed := bytes.TrimSpace(data)
+	var err error
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		err = json.Unmarshal(trimmed, &cfg)
+	} else {
+		err = yaml.Unmarshal(trimmed, &cfg)
+	}
+	if err != nil {
+		return FilterConfig{}, fmt.Errorf("parse filter config: %w", err)
+	}
+	return cfg, nil
+}
+
+// compileRules validates and compiles each RuleConfig into a matchable
+// rule, in order.
+func compileRules(configs []RuleConfig) ([]*rule, error) {
+	compiled := make([]*rule, 0, len(configs))
+
+	for i, rc := range configs {
+		if rc.Action == "" {
+			return nil, fmt.Errorf("rule %d: action is required", i)
+		}
+		switch rc.Action {
+		case ActionAllow, ActionDeny, ActionMITM, ActionBypassUpstream:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", i, rc.Action)
+		}
+
+		r := &rule{
+			desc:         fmt.Sprintf("rule %d (%s)", i, rc.Action),
+			host:         strings.ToLower(rc.Host),
+			hostWildcard: strings.ToLower(trimWildcardPrefix(rc.HostWildcard)),
+			pathPrefix:   rc.PathPrefix,
+			action:       rc.Action,
+		}
+
+		if rc.HostRegex != "" {
+			re, err := regexp.Compile(rc.HostRegex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: compile host_regex %q: %w", i, rc.HostRegex, err)
+			}
+			r.hostRegex = re
+		}
+
+		if rc.PathRegex != "" {
+			re, err := regexp.Compile(rc.PathRegex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: compile path_regex %q: %w", i, rc.PathRegex, err)
+			}
+			r.pathRegex = re
+		}
+
+		if rc.ClientCIDR != "" {
+			_, cidr, err := net.ParseCIDR(rc.ClientCIDR)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: parse client_cidr %q: %w", i, rc.ClientCIDR, err)
+			}
+			r.clientCIDR = cidr
+		}
+
+		compiled = append(compiled, r)
+	}
+
+	return compiled, nil
+}
+
+// trimWildcardPrefix turns "*.example.com" into ".example.com", which is
+// what rule.matchesHost does a suffix match against. Any leading "*" is
+// dropped, so "*foo" becomes a (less useful) suffix match on "foo" rather
+// than erroring; patterns without a leading "*" are left as-is.
+func trimWildcardPrefix(pattern string) string {
+	if len(pattern) >= 2 && pattern[0] == '*' {
+		return pattern[1:]
+	}
+	return pattern
+}
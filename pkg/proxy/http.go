@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"io"
 	"log"
 	"net"
@@ -9,40 +10,44 @@ import (
 	"time"
 )
 
-// StartHTTPServer starts an HTTP server for proxying on the given address
-func StartHTTPServer(addr string, cm *ConnManager, filter *RequestFilter) error {
+// StartHTTPServer starts an HTTP server for proxying on the given address,
+// running until ctx is canceled.
+func StartHTTPServer(ctx context.Context, addr string, deps *Deps, drainTimeout time.Duration) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		handleHTTPProxy(w, r, cm, filter)
+		handleHTTPProxy(w, r, deps)
 	})
 
-	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
-	}
-
 	log.Printf("Starting HTTP proxy server on %s", addr)
-	return server.ListenAndServe()
+	return NewServer(deps.ConnManager, drainTimeout).ServeHTTP(ctx, addr, mux)
 }
-func StartHAProxyListener(addr string, cm *ConnManager, filter *RequestFilter) error {
+
+// StartHAProxyListener starts the HAProxy-forwarded HTTP listener, running
+// until ctx is canceled.
+func StartHAProxyListener(ctx context.Context, addr string, deps *Deps, drainTimeout time.Duration) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		handleHTTPProxy(w, r, cm, filter)
+		handleHTTPProxy(w, r, deps)
 	})
 
-	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
-	}
-
 	log.Printf("Starting HTTP proxy server on %s", addr)
-	return server.ListenAndServe()
+	return NewServer(deps.ConnManager, drainTimeout).ServeHTTP(ctx, addr, mux)
 }
 
-func handleHTTPProxy(w http.ResponseWriter, r *http.Request, cm *ConnManager, filter *RequestFilter) {
+func handleHTTPProxy(w http.ResponseWriter, r *http.Request, deps *Deps) {
 	log.Printf("HTTP request from %s for host %s", r.RemoteAddr, r.Host)
 
-	if !filter.AllowHTTP(r.Host, r.URL.Path) {
+	if r.Method == http.MethodConnect {
+		handleConnect(w, r, deps)
+		return
+	}
+
+	username, ok := authenticateProxyClient(w, r, deps)
+	if !ok {
+		return
+	}
+
+	if !deps.Filter.AllowHTTP(r.Host, r.URL.Path, stripPort(r.RemoteAddr)) {
 		http.Error(w, "Blocked by proxy filter", http.StatusForbidden)
 		log.Printf("Request blocked by filter host=%s path=%s", r.Host, r.URL.Path)
 		return
@@ -62,8 +67,14 @@ func handleHTTPProxy(w http.ResponseWriter, r *http.Request, cm *ConnManager, fi
 	}
 	defer clientBuf.Flush()
 
-	// Dial upstream HTTP server on default port 80
-	serverConn, err := net.DialTimeout("tcp", r.Host+":80", 10*time.Second)
+	// Dial upstream HTTP server on default port 80, through the upstream
+	// pool if one is configured and the filter hasn't opted this host out
+	// of chaining.
+	pool := deps.UpstreamPool
+	if deps.Filter.BypassUpstream(r.Host, r.URL.Path, stripPort(r.RemoteAddr)) {
+		pool = nil
+	}
+	serverConn, err := dialOutTimeout(pool, "tcp", r.Host+":80", clientConn.RemoteAddr().String(), 10*time.Second)
 	if err != nil {
 		http.Error(w, "Failed to dial upstream server "+r.Host, http.StatusBadGateway)
 		clientConn.Close()
@@ -74,17 +85,18 @@ func handleHTTPProxy(w http.ResponseWriter, r *http.Request, cm *ConnManager, fi
 		ClientAddr: clientConn.RemoteAddr().String(),
 		ServerAddr: serverConn.RemoteAddr().String(),
 		Hostname:   r.Host,
+		Username:   username,
 		Protocol:   "http",
 		CreatedAt:  time.Now(),
 	}
 
-	id := cm.Add(clientConn, serverConn, meta)
+	id := deps.ConnManager.Add(clientConn, serverConn, meta)
 	log.Printf("Tracking HTTP connection id=%s client=%s server=%s", id, meta.ClientAddr, meta.ServerAddr)
 
 	// Write original request bytes to upstream server
 	if err := r.Write(serverConn); err != nil {
 		log.Printf("Error forwarding request to server: %v", err)
-		closeConnPair(cm, id)
+		closeConnPair(deps.ConnManager, id)
 		return
 	}
 
@@ -96,7 +108,7 @@ func handleHTTPProxy(w http.ResponseWriter, r *http.Request, cm *ConnManager, fi
 
 	wg.Wait()
 
-	closeConnPair(cm, id)
+	closeConnPair(deps.ConnManager, id)
 }
 
 func proxyCopy(wg *sync.WaitGroup, dst net.Conn, src io.Reader) {
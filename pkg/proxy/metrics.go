@@ -1,8 +1,10 @@
 package proxy
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,6 +17,7 @@ type ProxyMetrics struct {
 	ProxyHAProxyConns prometheus.Gauge
 	HTTPConnCount     prometheus.Gauge
 	HTTPSConnCount    prometheus.Gauge
+	JA3Fingerprints   *prometheus.CounterVec
 }
 
 // global metrics instance
@@ -39,6 +42,10 @@ var proxyMetrics = &ProxyMetrics{
 		Name: "proxy_https_connections",
 		Help: "Number of active HTTPS connections",
 	}),
+	JA3Fingerprints: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_tls_ja3_fingerprint_total",
+		Help: "Count of TLS connections seen per JA3 fingerprint",
+	}, []string{"ja3"}),
 }
 
 // RegisterMetrics registers all proxy metrics to Prometheus default registry
@@ -48,6 +55,7 @@ func RegisterMetrics() {
 	prometheus.MustRegister(proxyMetrics.ProxyHAProxyConns)
 	prometheus.MustRegister(proxyMetrics.HTTPConnCount)
 	prometheus.MustRegister(proxyMetrics.HTTPSConnCount)
+	prometheus.MustRegister(proxyMetrics.JA3Fingerprints)
 }
 
 // UpdateMetrics updates current metrics from atomic counters (thread-safe)
@@ -59,12 +67,13 @@ func UpdateMetrics(clientProxy, proxyServer, proxyHA, httpConns, httpsConns int6
 	proxyMetrics.HTTPSConnCount.Set(float64(httpsConns))
 }
 
-// StartMetricsServer exposes the /metrics HTTP endpoint on given addr
-func StartMetricsServer(addr string) error {
+// StartMetricsServer exposes the /metrics HTTP endpoint on given addr,
+// running until ctx is canceled.
+func StartMetricsServer(ctx context.Context, addr string, drainTimeout time.Duration) error {
 	RegisterMetrics()
-	http.Handle("/metrics", promhttp.Handler())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 
 	log.Printf("Starting metrics HTTP server on %s", addr)
-	return http.ListenAndServe(addr, nil)
+	return NewServer(nil, drainTimeout).ServeHTTP(ctx, addr, mux)
 }
-
@@ -0,0 +1,205 @@
+// Package mitm provides an in-process TLS man-in-the-middle engine: a
+// persisted root CA, on-the-fly leaf certificate generation cached by SNI,
+// and a pluggable request/response interceptor chain.
+package mitm
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const (
+	leafCertCacheSize = 1024
+	leafCertValidity  = 365 * 24 * time.Hour
+	caKeyBits         = 2048
+)
+
+// CAStore owns the root CA used to sign on-the-fly leaf certificates and
+// caches the leaves it generates so repeated connections to the same SNI
+// don't pay the RSA keygen cost again.
+type CAStore struct {
+	mu     sync.Mutex
+	caCert *x509.Certificate
+	caKey  *rsa.PrivateKey
+	cache  *lru.Cache[string, *tls.Certificate]
+}
+
+// NewCAStore loads the CA key/cert pair from certPath/keyPath, generating
+// and persisting a new self-signed CA the first time either file is
+// missing.
+func NewCAStore(certPath, keyPath string) (*CAStore, error) {
+	cache, err := lru.New[string, *tls.Certificate](leafCertCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("create leaf cert cache: %w", err)
+	}
+
+	cert, key, err := loadOrCreateCA(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CAStore{caCert: cert, caKey: key, cache: cache}, nil
+}
+
+// loadOrCreateCA reads an existing CA from disk, or generates and persists
+// a new one if certPath/keyPath don't exist yet.
+func loadOrCreateCA(certPath, keyPath string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	if certErr == nil && keyErr == nil {
+		cert, key, err := parseCA(certPEM, keyPEM)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse existing CA: %w", err)
+		}
+		log.Printf("mitm: loaded CA from %s", certPath)
+		return cert, key, nil
+	}
+
+	log.Printf("mitm: generating new CA, persisting to %s / %s", certPath, keyPath)
+	return generateAndPersistCA(certPath, keyPath)
+}
+
+func parseCA(certPEM, keyPEM []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("invalid CA certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("invalid CA key PEM")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func generateAndPersistCA(certPath, keyPath string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, caKeyBits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate CA key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate CA serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "L7Proxy MITM Root CA",
+			Organization: []string{"L7Proxy"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create CA certificate: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return nil, nil, fmt.Errorf("persist CA certificate: %w", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return nil, nil, fmt.Errorf("persist CA key: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// LeafCertificate returns a TLS certificate for host, signed by the store's
+// CA, generating and caching a new one on first use.
+func (s *CAStore) LeafCertificate(host string) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cert, ok := s.cache.Get(host); ok {
+		return cert, nil
+	}
+
+	cert, err := s.signLeaf(host)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.Add(host, cert)
+	return cert, nil
+}
+
+func (s *CAStore) signLeaf(host string) (*tls.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generate leaf key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate leaf serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, &key.PublicKey, s.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("sign leaf certificate for %s: %w", host, err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// GetCertificate is usable directly as tls.Config.GetCertificate; it picks
+// the requested host from the ClientHello's SNI.
+func (s *CAStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	if host == "" {
+		return nil, fmt.Errorf("mitm: no SNI in ClientHello, cannot select leaf certificate")
+	}
+	return s.LeafCertificate(host)
+}
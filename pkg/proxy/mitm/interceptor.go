@@ -0,0 +1,43 @@
+package mitm
+
+import "net/http"
+
+// RequestInterceptor inspects or rewrites decrypted requests/responses that
+// pass through the MITM engine, analogous to goproxy's OnRequest/OnResponse
+// hooks. OnRequest may return a non-nil resp to short-circuit the request
+// (the upstream is never contacted and resp is sent to the client instead).
+type RequestInterceptor interface {
+	OnRequest(req *http.Request) (*http.Request, *http.Response)
+	OnResponse(resp *http.Response, req *http.Request) *http.Response
+}
+
+// InterceptorChain runs a sequence of RequestInterceptors in order.
+type InterceptorChain struct {
+	interceptors []RequestInterceptor
+}
+
+// Use appends i to the chain.
+func (c *InterceptorChain) Use(i RequestInterceptor) {
+	c.interceptors = append(c.interceptors, i)
+}
+
+// RunRequest passes req through each interceptor's OnRequest in turn,
+// stopping early if one returns a response.
+func (c *InterceptorChain) RunRequest(req *http.Request) (*http.Request, *http.Response) {
+	for _, i := range c.interceptors {
+		var resp *http.Response
+		req, resp = i.OnRequest(req)
+		if resp != nil {
+			return req, resp
+		}
+	}
+	return req, nil
+}
+
+// RunResponse passes resp through each interceptor's OnResponse in turn.
+func (c *InterceptorChain) RunResponse(resp *http.Response, req *http.Request) *http.Response {
+	for _, i := range c.interceptors {
+		resp = i.OnResponse(resp, req)
+	}
+	return resp
+}
@@ -0,0 +1,127 @@
+package mitm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recordingInterceptor tags the request/response so tests can tell which
+// interceptors ran, and optionally short-circuits with a canned response.
+type recordingInterceptor struct {
+	name         string
+	shortCircuit bool
+	calls        *[]string
+}
+
+func (r *recordingInterceptor) OnRequest(req *http.Request) (*http.Request, *http.Response) {
+	*r.calls = append(*r.calls, r.name+":request")
+	if r.shortCircuit {
+		rec := httptest.NewRecorder()
+		rec.WriteHeader(http.StatusForbidden)
+		return req, rec.Result()
+	}
+	return req, nil
+}
+
+func (r *recordingInterceptor) OnResponse(resp *http.Response, req *http.Request) *http.Response {
+	*r.calls = append(*r.calls, r.name+":response")
+	return resp
+}
+
+func newTestRequest(t *testing.T) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	return req
+}
+
+func TestInterceptorChainRunRequestStopsAtFirstShortCircuit(t *testing.T) {
+	var calls []string
+	chain := &InterceptorChain{}
+	chain.Use(&recordingInterceptor{name: "first", calls: &calls})
+	chain.Use(&recordingInterceptor{name: "second", shortCircuit: true, calls: &calls})
+	chain.Use(&recordingInterceptor{name: "third", calls: &calls})
+
+	_, resp := chain.RunRequest(newTestRequest(t))
+
+	if resp == nil {
+		t.Fatal("expected a short-circuit response from the second interceptor")
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("resp.StatusCode = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	wantCalls := []string{"first:request", "second:request"}
+	if !equalStrings(calls, wantCalls) {
+		t.Errorf("calls = %v, want %v (third should never run)", calls, wantCalls)
+	}
+}
+
+func TestInterceptorChainRunRequestRunsAllWhenNoneShortCircuit(t *testing.T) {
+	var calls []string
+	chain := &InterceptorChain{}
+	chain.Use(&recordingInterceptor{name: "first", calls: &calls})
+	chain.Use(&recordingInterceptor{name: "second", calls: &calls})
+
+	_, resp := chain.RunRequest(newTestRequest(t))
+
+	if resp != nil {
+		t.Errorf("expected no short-circuit response, got status %d", resp.StatusCode)
+	}
+	wantCalls := []string{"first:request", "second:request"}
+	if !equalStrings(calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", calls, wantCalls)
+	}
+}
+
+func TestInterceptorChainRunResponsePassesThroughEveryInterceptor(t *testing.T) {
+	var calls []string
+	chain := &InterceptorChain{}
+	chain.Use(&recordingInterceptor{name: "first", calls: &calls})
+	chain.Use(&recordingInterceptor{name: "second", calls: &calls})
+
+	req := newTestRequest(t)
+	in := httptest.NewRecorder()
+	in.WriteHeader(http.StatusOK)
+	resp := chain.RunResponse(in.Result(), req)
+
+	if resp == nil {
+		t.Fatal("expected a non-nil response")
+	}
+	wantCalls := []string{"first:response", "second:response"}
+	if !equalStrings(calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", calls, wantCalls)
+	}
+}
+
+func TestInterceptorChainWithNoInterceptorsIsANoOp(t *testing.T) {
+	chain := &InterceptorChain{}
+	req := newTestRequest(t)
+
+	gotReq, resp := chain.RunRequest(req)
+	if gotReq != req || resp != nil {
+		t.Errorf("RunRequest on empty chain = (%v, %v), want (%v, nil)", gotReq, resp, req)
+	}
+
+	in := httptest.NewRecorder()
+	in.WriteHeader(http.StatusOK)
+	want := in.Result()
+	got := chain.RunResponse(want, req)
+	if got != want {
+		t.Errorf("RunResponse on empty chain = %v, want %v unchanged", got, want)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
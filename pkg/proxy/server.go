@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultDrainTimeout is used when a caller doesn't specify how long to wait
+// for in-flight connections to finish before force-closing them on shutdown.
+const DefaultDrainTimeout = 10 * time.Second
+
+// Server runs either an *http.Server or a raw net.Listener accept loop and
+// drains it gracefully when its context is canceled: stop accepting new
+// connections, ask the underlying http.Server to shut down, send a
+// "Connection: close" hint to every plain-HTTP connection tracked in cm (see
+// ConnManager.SignalClose), then wait up to drainTimeout before force-closing
+// whatever is left.
+type Server struct {
+	cm           *ConnManager
+	drainTimeout time.Duration
+}
+
+// NewServer returns a Server that drains connections tracked in cm, waiting
+// up to drainTimeout for them to finish on their own.
+func NewServer(cm *ConnManager, drainTimeout time.Duration) *Server {
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	return &Server{cm: cm, drainTimeout: drainTimeout}
+}
+
+// ServeHTTP runs an *http.Server bound to addr with handler until ctx is
+// canceled, then drains it gracefully.
+func (s *Server) ServeHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	httpSrv := &http.Server{Addr: addr, Handler: handler}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpSrv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+		defer cancel()
+		if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("server: %s: error shutting down http.Server: %v", addr, err)
+		}
+		s.drain(addr)
+		return nil
+	}
+}
+
+// ServeListener runs a raw net.Listener accept loop on addr, handing each
+// accepted connection to handle in its own goroutine, until ctx is
+// canceled, then drains it gracefully.
+func (s *Server) ServeListener(ctx context.Context, addr string, handle func(net.Conn)) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					if errors.Is(err, net.ErrClosed) {
+						return
+					}
+					log.Printf("server: %s: accept error: %v", addr, err)
+					continue
+				}
+			}
+			go handle(conn)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		ln.Close()
+		s.drain(addr)
+		<-done
+		return nil
+	case <-done:
+		return errors.New("server: " + addr + ": listener closed unexpectedly")
+	}
+}
+
+// drain sends a "Connection: close" hint to every plain-HTTP connection
+// tracked in cm (other protocols get no hint), then waits up to
+// s.drainTimeout for tracked connections to finish before force-closing
+// whatever is left.
+func (s *Server) drain(addr string) {
+	if s.cm == nil {
+		return
+	}
+
+	log.Printf("server: %s: draining connections (up to %s)", addr, s.drainTimeout)
+	s.cm.SignalClose()
+
+	deadline := time.After(s.drainTimeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-deadline:
+			remaining := s.cm.Count()
+			if remaining > 0 {
+				log.Printf("server: %s: drain timeout reached with %d connection(s) left, force-closing", addr, remaining)
+				s.cm.ForceCloseAll()
+			}
+			return
+		case <-ticker.C:
+			if s.cm.Count() == 0 {
+				log.Printf("server: %s: all connections drained", addr)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerDrainReturnsEarlyWhenConnectionsFinishOnTheirOwn(t *testing.T) {
+	cm := NewConnManager()
+	client := &fakeConn{}
+	server := &fakeConn{}
+	id := cm.Add(client, server, ConnMeta{Protocol: "http"})
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cm.Remove(id)
+	}()
+
+	s := NewServer(cm, time.Second)
+	start := time.Now()
+	s.drain("test-addr")
+	elapsed := time.Since(start)
+
+	if elapsed >= time.Second {
+		t.Errorf("drain took %s, expected it to return shortly after the connection finished, well under the 1s timeout", elapsed)
+	}
+	if client.isClosed() {
+		t.Error("drain should not force-close a connection that finished on its own before the timeout")
+	}
+}
+
+func TestServerDrainForceClosesRemainingConnectionsAfterTimeout(t *testing.T) {
+	cm := NewConnManager()
+	client := &fakeConn{}
+	server := &fakeConn{}
+	cm.Add(client, server, ConnMeta{Protocol: "https_mitm"})
+
+	s := NewServer(cm, 50*time.Millisecond)
+	s.drain("test-addr")
+
+	if !client.isClosed() || !server.isClosed() {
+		t.Error("expected drain to force-close connections still present after drainTimeout elapses")
+	}
+	if cm.Count() != 0 {
+		t.Errorf("cm.Count() = %d, want 0 after a forced drain", cm.Count())
+	}
+}
+
+func TestServerDrainIsNoOpWithoutAConnManager(t *testing.T) {
+	s := NewServer(nil, 10*time.Millisecond)
+	s.drain("test-addr") // must not panic
+}
+
+func TestNewServerAppliesDefaultDrainTimeout(t *testing.T) {
+	s := NewServer(NewConnManager(), 0)
+	if s.drainTimeout != DefaultDrainTimeout {
+		t.Errorf("drainTimeout = %s, want default %s", s.drainTimeout, DefaultDrainTimeout)
+	}
+}
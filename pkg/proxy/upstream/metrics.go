@@ -0,0 +1,65 @@
+package upstream
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dialSuccesses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_upstream_dial_success_total",
+		Help: "Count of successful dials through each upstream",
+	}, []string{"upstream"})
+
+	dialFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_upstream_dial_failure_total",
+		Help: "Count of failed dials through each upstream",
+	}, []string{"upstream"})
+
+	dialLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxy_upstream_dial_latency_seconds",
+		Help:    "Latency of successful dials through each upstream",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream"})
+
+	checkSuccesses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_upstream_healthcheck_success_total",
+		Help: "Count of successful health-check probes per upstream",
+	}, []string{"upstream"})
+
+	checkFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_upstream_healthcheck_failure_total",
+		Help: "Count of failed health-check probes per upstream",
+	}, []string{"upstream"})
+
+	checkLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxy_upstream_healthcheck_latency_seconds",
+		Help:    "Latency of health-check probes per upstream",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream"})
+)
+
+// RegisterMetrics registers all upstream pool metrics to Prometheus's
+// default registry. Safe to call once at startup alongside proxy.RegisterMetrics.
+func RegisterMetrics() {
+	prometheus.MustRegister(dialSuccesses, dialFailures, dialLatency, checkSuccesses, checkFailures, checkLatency)
+}
+
+func recordDialSuccess(upstream string, latency time.Duration) {
+	dialSuccesses.WithLabelValues(upstream).Inc()
+	dialLatency.WithLabelValues(upstream).Observe(latency.Seconds())
+}
+
+func recordDialFailure(upstream string) {
+	dialFailures.WithLabelValues(upstream).Inc()
+}
+
+func recordCheckSuccess(upstream string, latency time.Duration) {
+	checkSuccesses.WithLabelValues(upstream).Inc()
+	checkLatency.WithLabelValues(upstream).Observe(latency.Seconds())
+}
+
+func recordCheckFailure(upstream string) {
+	checkFailures.WithLabelValues(upstream).Inc()
+}
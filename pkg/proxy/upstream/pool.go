@@ -0,0 +1,254 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Strategy selects which healthy upstream in a Pool serves the next dial.
+type Strategy string
+
+const (
+	RoundRobin       Strategy = "round_robin"
+	LeastLatency     Strategy = "least_latency"
+	RandomWeighted   Strategy = "random_weighted"
+	StickyByClientIP Strategy = "sticky_by_client_ip"
+)
+
+// state tracks the health of a single pooled upstream.
+type state struct {
+	up *Upstream
+
+	mu      sync.RWMutex
+	healthy bool
+	latency time.Duration
+}
+
+func (s *state) snapshot() (healthy bool, latency time.Duration) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.healthy, s.latency
+}
+
+func (s *state) record(healthy bool, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.healthy = healthy
+	s.latency = latency
+}
+
+// Pool periodically health-checks a set of upstreams and selects among the
+// healthy ones according to Strategy.
+type Pool struct {
+	states   []*state
+	strategy Strategy
+	probeURL string
+	interval time.Duration
+
+	rrCounter uint64
+
+	stickyMu sync.Mutex
+	sticky   map[string]*state
+}
+
+// NewPool builds a Pool over upstreams, health-checked every checkInterval
+// by fetching probeURL through each.
+func NewPool(upstreams []*Upstream, strategy Strategy, probeURL string, checkInterval time.Duration) *Pool {
+	states := make([]*state, len(upstreams))
+	for i, u := range upstreams {
+		states[i] = &state{up: u, healthy: true} // optimistic until the first check
+	}
+	return &Pool{
+		states:   states,
+		strategy: strategy,
+		probeURL: probeURL,
+		interval: checkInterval,
+		sticky:   make(map[string]*state),
+	}
+}
+
+// Run health-checks every upstream on Pool's interval until ctx is canceled.
+func (p *Pool) Run(ctx context.Context) {
+	p.checkAll(ctx)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkAll(ctx)
+		}
+	}
+}
+
+func (p *Pool) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, st := range p.states {
+		wg.Add(1)
+		go func(st *state) {
+			defer wg.Done()
+			p.checkOne(ctx, st)
+		}(st)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) checkOne(ctx context.Context, st *state) {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return st.up.Dial(ctx, network, addr)
+			},
+		},
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(checkCtx, http.MethodGet, p.probeURL, nil)
+	if err != nil {
+		log.Printf("upstream %s: invalid probe URL %q: %v", st.up.Label, p.probeURL, err)
+		st.record(false, 0)
+		recordCheckFailure(st.up.Label)
+		return
+	}
+
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	if err != nil || resp.StatusCode >= 500 {
+		st.record(false, latency)
+		recordCheckFailure(st.up.Label)
+		return
+	}
+	resp.Body.Close()
+
+	st.record(true, latency)
+	recordCheckSuccess(st.up.Label, latency)
+}
+
+// Dial picks an upstream according to Pool's strategy and dials addr
+// through it. clientIP is only consulted by the sticky-by-client-IP
+// strategy.
+func (p *Pool) Dial(ctx context.Context, network, addr, clientIP string) (net.Conn, error) {
+	st := p.pick(clientIP)
+	if st == nil {
+		return nil, fmt.Errorf("upstream pool: no healthy upstream available")
+	}
+
+	start := time.Now()
+	conn, err := st.up.Dial(ctx, network, addr)
+	if err != nil {
+		recordDialFailure(st.up.Label)
+		return nil, fmt.Errorf("upstream %s: %w", st.up.Label, err)
+	}
+	recordDialSuccess(st.up.Label, time.Since(start))
+	return conn, nil
+}
+
+func (p *Pool) healthyStates() []*state {
+	healthy := make([]*state, 0, len(p.states))
+	for _, st := range p.states {
+		if ok, _ := st.snapshot(); ok {
+			healthy = append(healthy, st)
+		}
+	}
+	if len(healthy) == 0 {
+		// Degrade to "try everything" rather than fail closed when every
+		// upstream looks down (the next probe cycle will recover them).
+		return p.states
+	}
+	return healthy
+}
+
+func (p *Pool) pick(clientIP string) *state {
+	healthy := p.healthyStates()
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	switch p.strategy {
+	case LeastLatency:
+		return pickLeastLatency(healthy)
+	case RandomWeighted:
+		return pickRandomWeighted(healthy)
+	case StickyByClientIP:
+		return p.pickSticky(clientIP, healthy)
+	default:
+		return p.pickRoundRobin(healthy)
+	}
+}
+
+func (p *Pool) pickRoundRobin(healthy []*state) *state {
+	n := atomic.AddUint64(&p.rrCounter, 1)
+	return healthy[int(n-1)%len(healthy)]
+}
+
+func pickLeastLatency(healthy []*state) *state {
+	best := healthy[0]
+	_, bestLatency := best.snapshot()
+	for _, st := range healthy[1:] {
+		_, latency := st.snapshot()
+		if latency > 0 && (bestLatency == 0 || latency < bestLatency) {
+			best, bestLatency = st, latency
+		}
+	}
+	return best
+}
+
+func pickRandomWeighted(healthy []*state) *state {
+	weights := make([]float64, len(healthy))
+	var total float64
+	for i, st := range healthy {
+		_, latency := st.snapshot()
+		w := 1.0
+		if latency > 0 {
+			w = 1.0 / float64(latency)
+		}
+		weights[i] = w
+		total += w
+	}
+
+	r := rand.Float64() * total
+	for i, w := range weights {
+		r -= w
+		if r <= 0 {
+			return healthy[i]
+		}
+	}
+	return healthy[len(healthy)-1]
+}
+
+func (p *Pool) pickSticky(clientIP string, healthy []*state) *state {
+	p.stickyMu.Lock()
+	defer p.stickyMu.Unlock()
+
+	if st, ok := p.sticky[clientIP]; ok {
+		if healthyOk, _ := st.snapshot(); healthyOk {
+			return st
+		}
+		delete(p.sticky, clientIP)
+	}
+
+	idx := hashClientIP(clientIP) % uint32(len(healthy))
+	st := healthy[idx]
+	p.sticky[clientIP] = st
+	return st
+}
+
+func hashClientIP(clientIP string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientIP))
+	return h.Sum32()
+}
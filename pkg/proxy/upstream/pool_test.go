@@ -0,0 +1,106 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestPool(t *testing.T, strategy Strategy, labels ...string) *Pool {
+	t.Helper()
+	upstreams := make([]*Upstream, len(labels))
+	for i, label := range labels {
+		upstreams[i] = &Upstream{Raw: label, Label: label, Kind: KindHTTPConnect, Addr: label}
+	}
+	return NewPool(upstreams, strategy, "http://probe.invalid", time.Hour)
+}
+
+func TestPoolPickRoundRobinCyclesThroughHealthyUpstreams(t *testing.T) {
+	p := newTestPool(t, RoundRobin, "a", "b", "c")
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, p.pick("").up.Label)
+	}
+
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pick sequence = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPoolPickRoundRobinSkipsUnhealthyUpstreams(t *testing.T) {
+	p := newTestPool(t, RoundRobin, "a", "b", "c")
+	p.states[1].record(false, 0) // "b" is down
+
+	for i := 0; i < 4; i++ {
+		if got := p.pick("").up.Label; got == "b" {
+			t.Fatalf("pick() returned unhealthy upstream %q", got)
+		}
+	}
+}
+
+func TestPoolPickLeastLatencyPrefersFastestHealthyUpstream(t *testing.T) {
+	p := newTestPool(t, LeastLatency, "slow", "fast", "medium")
+	p.states[0].record(true, 300*time.Millisecond)
+	p.states[1].record(true, 10*time.Millisecond)
+	p.states[2].record(true, 100*time.Millisecond)
+
+	if got := p.pick("").up.Label; got != "fast" {
+		t.Errorf("pick() = %q, want %q", got, "fast")
+	}
+}
+
+func TestPoolPickRandomWeightedOnlyReturnsHealthyUpstreams(t *testing.T) {
+	p := newTestPool(t, RandomWeighted, "a", "b", "c")
+	p.states[1].record(false, 0)
+
+	for i := 0; i < 20; i++ {
+		if got := p.pick("").up.Label; got == "b" {
+			t.Fatalf("pick() returned unhealthy upstream %q", got)
+		}
+	}
+}
+
+func TestPoolPickStickyByClientIPReturnsSameUpstreamForSameClient(t *testing.T) {
+	p := newTestPool(t, StickyByClientIP, "a", "b", "c")
+
+	first := p.pick("10.0.0.1").up.Label
+	for i := 0; i < 5; i++ {
+		if got := p.pick("10.0.0.1").up.Label; got != first {
+			t.Errorf("pick(%q) = %q, want consistently %q", "10.0.0.1", got, first)
+		}
+	}
+}
+
+func TestPoolPickStickyByClientIPFailsOverWhenPinnedUpstreamGoesDown(t *testing.T) {
+	p := newTestPool(t, StickyByClientIP, "a", "b", "c")
+
+	pinned := p.pick("10.0.0.1")
+	pinned.record(false, 0)
+
+	got := p.pick("10.0.0.1")
+	if got == pinned {
+		t.Error("expected pickSticky to fail over once the pinned upstream is unhealthy")
+	}
+}
+
+func TestPoolPickDegradesToAllUpstreamsWhenNoneAreHealthy(t *testing.T) {
+	p := newTestPool(t, RoundRobin, "a", "b")
+	p.states[0].record(false, 0)
+	p.states[1].record(false, 0)
+
+	st := p.pick("")
+	if st == nil {
+		t.Fatal("expected pick to degrade to trying an unhealthy upstream rather than return nil")
+	}
+}
+
+func TestPoolPickReturnsNilWhenPoolIsEmpty(t *testing.T) {
+	p := newTestPool(t, RoundRobin)
+
+	if st := p.pick(""); st != nil {
+		t.Errorf("pick() on an empty pool = %v, want nil", st)
+	}
+}
@@ -0,0 +1,204 @@
+// Package upstream implements outbound proxy chaining: dialing destination
+// addresses through a pool of parent proxies (HTTP CONNECT, SOCKS5, or SSH
+// tunnels) instead of dialing origins directly. SSH upstreams are connected
+// without verifying the parent server's host key.
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+// Kind identifies the wire protocol used to reach an upstream.
+type Kind string
+
+const (
+	KindHTTPConnect Kind = "http"
+	KindSOCKS5      Kind = "socks5"
+	KindSSH         Kind = "ssh"
+)
+
+// Upstream describes a single parent proxy that destination connections can
+// be chained through.
+type Upstream struct {
+	Raw   string
+	Label string // Raw with any embedded userinfo stripped, safe for metrics labels and logs
+	Kind  Kind
+	Addr  string // host:port of the upstream itself
+	User  string
+	Pass  string
+
+	sshMu     sync.Mutex
+	sshClient *ssh.Client // lazily established, nil until first successful connect for ssh upstreams
+}
+
+// Parse parses a URI like "socks5://user:pw@h:1080", "http://h:8080", or
+// "ssh://user@h:22" into an Upstream. The password for ssh upstreams, if
+// any, is used as the SSH password.
+func Parse(rawURL string) (*Upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse upstream URL %q: %w", rawURL, err)
+	}
+
+	var kind Kind
+	switch u.Scheme {
+	case "http", "https":
+		kind = KindHTTPConnect
+	case "socks5":
+		kind = KindSOCKS5
+	case "ssh":
+		kind = KindSSH
+	default:
+		return nil, fmt.Errorf("unsupported upstream scheme %q in %q", u.Scheme, rawURL)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		switch kind {
+		case KindHTTPConnect:
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		case KindSOCKS5:
+			addr = net.JoinHostPort(u.Hostname(), "1080")
+		case KindSSH:
+			addr = net.JoinHostPort(u.Hostname(), "22")
+		}
+	}
+
+	pass, _ := u.User.Password()
+
+	redacted := *u
+	redacted.User = nil
+	return &Upstream{
+		Raw:   rawURL,
+		Label: redacted.String(),
+		Kind:  kind,
+		Addr:  addr,
+		User:  u.User.Username(),
+		Pass:  pass,
+	}, nil
+}
+
+// Dial connects to addr (network "tcp") through this upstream.
+func (u *Upstream) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch u.Kind {
+	case KindHTTPConnect:
+		return u.dialHTTPConnect(ctx, addr)
+	case KindSOCKS5:
+		return u.dialSOCKS5(ctx, network, addr)
+	case KindSSH:
+		return u.dialSSH(ctx, network, addr)
+	default:
+		return nil, fmt.Errorf("upstream %s: unknown kind %q", u.Label, u.Kind)
+	}
+}
+
+func (u *Upstream) dialHTTPConnect(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial HTTP CONNECT upstream %s: %w", u.Addr, err)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != "" {
+		req.SetBasicAuth(u.User, u.Pass)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write CONNECT to upstream %s: %w", u.Addr, err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read CONNECT response from upstream %s: %w", u.Addr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("upstream %s refused CONNECT to %s: %s", u.Addr, addr, resp.Status)
+	}
+	return conn, nil
+}
+
+func (u *Upstream) dialSOCKS5(ctx context.Context, network, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if u.User != "" {
+		auth = &proxy.Auth{User: u.User, Password: u.Pass}
+	}
+	dialer, err := proxy.SOCKS5(network, u.Addr, auth, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("create SOCKS5 dialer for upstream %s: %w", u.Addr, err)
+	}
+	if d, ok := dialer.(proxy.ContextDialer); ok {
+		return d.DialContext(ctx, network, addr)
+	}
+	return dialer.Dial(network, addr)
+}
+
+func (u *Upstream) dialSSH(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := u.sshClientConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s via SSH upstream %s: %w", addr, u.Addr, err)
+	}
+	return conn, nil
+}
+
+// sshClientConn returns the cached SSH client, establishing it on first use.
+// A fresh connection is made if the cached one has gone away. Guarded by
+// sshMu since Dial is called concurrently from every proxied connection's
+// goroutine and they can all race to establish the first client.
+func (u *Upstream) sshClientConn(ctx context.Context) (*ssh.Client, error) {
+	u.sshMu.Lock()
+	defer u.sshMu.Unlock()
+
+	if u.sshClient != nil {
+		if _, _, err := u.sshClient.SendRequest("keepalive@l7proxy", true, nil); err == nil {
+			return u.sshClient, nil
+		}
+		u.sshClient.Close()
+		u.sshClient = nil
+	}
+
+	config := &ssh.ClientConfig{
+		User:            u.User,
+		Auth:            []ssh.AuthMethod{ssh.Password(u.Pass)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), //nolint:gosec // parent SSH host key trust is out of scope; see package docs
+		Timeout:         10 * time.Second,
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial SSH upstream %s: %w", u.Addr, err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, u.Addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH handshake with upstream %s: %w", u.Addr, err)
+	}
+
+	client := ssh.NewClient(sshConn, chans, reqs)
+	u.sshClient = client
+	return client, nil
+}
@@ -0,0 +1,108 @@
+package upstream
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawURL   string
+		wantKind Kind
+		wantAddr string
+		wantUser string
+		wantPass string
+	}{
+		{
+			name:     "http with explicit port",
+			rawURL:   "http://h:8080",
+			wantKind: KindHTTPConnect,
+			wantAddr: "h:8080",
+		},
+		{
+			name:     "http without port defaults to 80",
+			rawURL:   "http://h",
+			wantKind: KindHTTPConnect,
+			wantAddr: "h:80",
+		},
+		{
+			name:     "https scheme also uses the HTTP CONNECT dialer",
+			rawURL:   "https://h:8443",
+			wantKind: KindHTTPConnect,
+			wantAddr: "h:8443",
+		},
+		{
+			name:     "socks5 without port defaults to 1080",
+			rawURL:   "socks5://h",
+			wantKind: KindSOCKS5,
+			wantAddr: "h:1080",
+		},
+		{
+			name:     "socks5 with credentials",
+			rawURL:   "socks5://user:pw@h:1080",
+			wantKind: KindSOCKS5,
+			wantAddr: "h:1080",
+			wantUser: "user",
+			wantPass: "pw",
+		},
+		{
+			name:     "ssh without port defaults to 22",
+			rawURL:   "ssh://user@h",
+			wantKind: KindSSH,
+			wantAddr: "h:22",
+			wantUser: "user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := Parse(tt.rawURL)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.rawURL, err)
+			}
+			if u.Kind != tt.wantKind {
+				t.Errorf("Kind = %q, want %q", u.Kind, tt.wantKind)
+			}
+			if u.Addr != tt.wantAddr {
+				t.Errorf("Addr = %q, want %q", u.Addr, tt.wantAddr)
+			}
+			if u.User != tt.wantUser {
+				t.Errorf("User = %q, want %q", u.User, tt.wantUser)
+			}
+			if u.Pass != tt.wantPass {
+				t.Errorf("Pass = %q, want %q", u.Pass, tt.wantPass)
+			}
+			if u.Raw != tt.rawURL {
+				t.Errorf("Raw = %q, want %q", u.Raw, tt.rawURL)
+			}
+		})
+	}
+}
+
+func TestParseRejectsUnsupportedScheme(t *testing.T) {
+	if _, err := Parse("ftp://h:21"); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+}
+
+func TestParseLabelStripsCredentials(t *testing.T) {
+	u, err := Parse("socks5://secretuser:secretpass@h:1080")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if u.Label == u.Raw {
+		t.Fatalf("Label %q should differ from Raw %q once credentials are stripped", u.Label, u.Raw)
+	}
+	for _, want := range []string{"secretuser", "secretpass"} {
+		if contains(u.Label, want) {
+			t.Errorf("Label %q must not contain credential %q", u.Label, want)
+		}
+	}
+}
+
+func contains(s, substr string) bool {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if s[i:i+len(substr)] == substr {
+			return true
+		}
+	}
+	return false
+}